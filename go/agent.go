@@ -0,0 +1,94 @@
+package kimi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// agentSpec holds what a generated agent file changes in the CLI's default
+// agent.
+type agentSpec struct {
+	// systemPrompt replaces the system prompt; it is written to system.md
+	// next to the agent file.
+	systemPrompt string
+	// tools, if not nil, replaces the default agent's tools.
+	tools []string
+	// excludeTools removes tools from the agent.
+	excludeTools []string
+}
+
+func (spec *agentSpec) empty() bool {
+	return spec.systemPrompt == "" && spec.tools == nil && spec.excludeTools == nil
+}
+
+// yaml returns the agent file for spec. Tool names are written as quoted
+// strings, which YAML reads like Go string literals.
+func (spec *agentSpec) yaml() string {
+	var b strings.Builder
+	b.WriteString("version: 1\nagent:\n  extend: default\n")
+	if spec.systemPrompt != "" {
+		b.WriteString("  system_prompt_path: ./system.md\n")
+	}
+	writeList := func(key string, names []string) {
+		switch {
+		case names == nil:
+		case len(names) == 0:
+			fmt.Fprintf(&b, "  %s: []\n", key)
+		default:
+			fmt.Fprintf(&b, "  %s:\n", key)
+			for _, name := range names {
+				fmt.Fprintf(&b, "    - %s\n", strconv.Quote(name))
+			}
+		}
+	}
+	writeList("tools", spec.tools)
+	writeList("exclude_tools", spec.excludeTools)
+	return b.String()
+}
+
+// agentDirPrefix starts the names of the temporary directories holding agent
+// files, which continue with the PID of the process that created them.
+const agentDirPrefix = "kimi-agent-"
+
+// writeAgentFile writes the agent file for spec to a new temporary directory,
+// and returns the directory and the agent file's path. The caller removes the
+// directory once the CLI has exited; the directories of processes that died
+// before they could are removed here.
+func writeAgentFile(spec *agentSpec) (dir, path string, err error) {
+	sweepAgentDirs(os.TempDir())
+	dir, err = os.MkdirTemp("", agentDirPrefix+strconv.Itoa(os.Getpid())+"-")
+	if err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(dir, "agent.yaml")
+	if spec.systemPrompt != "" {
+		err = os.WriteFile(filepath.Join(dir, "system.md"), []byte(spec.systemPrompt), 0o600)
+	}
+	if err == nil {
+		err = os.WriteFile(path, []byte(spec.yaml()), 0o600)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, path, nil
+}
+
+// sweepAgentDirs removes the agent directories in tempDir left behind by
+// processes that are no longer running, e.g. because they were killed before
+// their sessions were closed. Directories of running processes, and ones
+// whose names carry no PID, are left alone.
+func sweepAgentDirs(tempDir string) {
+	dirs, _ := filepath.Glob(filepath.Join(tempDir, agentDirPrefix+"*-*"))
+	for _, dir := range dirs {
+		pid, _, _ := strings.Cut(strings.TrimPrefix(filepath.Base(dir), agentDirPrefix), "-")
+		n, err := strconv.Atoi(pid)
+		if err != nil || n <= 0 || n == os.Getpid() || processAlive(n) {
+			continue
+		}
+		os.RemoveAll(dir)
+	}
+}
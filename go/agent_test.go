@@ -0,0 +1,123 @@
+package kimi
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestAgentSpec_YAML(t *testing.T) {
+	tests := []struct {
+		name string
+		spec agentSpec
+		want string
+	}{
+		{"system prompt", agentSpec{systemPrompt: "You are a release bot."}, `version: 1
+agent:
+  extend: default
+  system_prompt_path: ./system.md
+`},
+		{"tools", agentSpec{
+			tools:        []string{"kimi_cli.tools.file:ReadFile", "kimi_cli.tools.file:Glob"},
+			excludeTools: []string{"kimi_cli.tools.shell:Shell"},
+		}, `version: 1
+agent:
+  extend: default
+  tools:
+    - "kimi_cli.tools.file:ReadFile"
+    - "kimi_cli.tools.file:Glob"
+  exclude_tools:
+    - "kimi_cli.tools.shell:Shell"
+`},
+		{"no tools", agentSpec{tools: []string{}}, `version: 1
+agent:
+  extend: default
+  tools: []
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.yaml(); got != tt.want {
+				t.Errorf("unexpected agent file:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteAgentFile(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	spec := &agentSpec{systemPrompt: "You are a release bot."}
+	dir, path, err := writeAgentFile(spec)
+	if err != nil {
+		t.Fatalf("writeAgentFile: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if filepath.Dir(path) != dir {
+		t.Errorf("expected agent file in %s, got %s", dir, path)
+	}
+	agent, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read agent file: %v", err)
+	}
+	if string(agent) != spec.yaml() {
+		t.Errorf("unexpected agent file:\n%s", agent)
+	}
+	prompt, err := os.ReadFile(filepath.Join(dir, "system.md"))
+	if err != nil {
+		t.Fatalf("read system prompt: %v", err)
+	}
+	if string(prompt) != "You are a release bot." {
+		t.Errorf("unexpected system prompt %q", prompt)
+	}
+}
+
+func TestWriteAgentFile_NoSystemPrompt(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	dir, _, err := writeAgentFile(&agentSpec{excludeTools: []string{"kimi_cli.tools.shell:Shell"}})
+	if err != nil {
+		t.Fatalf("writeAgentFile: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := os.Stat(filepath.Join(dir, "system.md")); !os.IsNotExist(err) {
+		t.Errorf("expected no system prompt file, got %v", err)
+	}
+}
+
+func TestSweepAgentDirs(t *testing.T) {
+	tmp := t.TempDir()
+	// A process that has exited.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dead := agentDirPrefix + strconv.Itoa(cmd.Process.Pid) + "-1"
+	own := agentDirPrefix + strconv.Itoa(os.Getpid()) + "-2"
+	// Named as before the PID was included.
+	unowned := agentDirPrefix + "3"
+	for _, name := range []string{dead, own, unowned} {
+		if err := os.Mkdir(filepath.Join(tmp, name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweepAgentDirs(tmp)
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if want := []string{own, unowned}; !slices.Equal(left, slices.Sorted(slices.Values(want))) {
+		t.Errorf("expected %v to be left, got %v", want, left)
+	}
+}
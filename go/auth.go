@@ -0,0 +1,70 @@
+package kimi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire/jsonrpc2"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// errorCodeChatProvider is the JSON-RPC error code the CLI answers a prompt
+// with when the model provider's API failed.
+const errorCodeChatProvider jsonrpc2.ErrorCode = -32003
+
+// authFailureMarkers are substrings, matched case-insensitively, of the
+// messages of provider errors caused by rejected credentials.
+var authFailureMarkers = []string{
+	"error code: 401",
+	"invalid_authentication",
+	"authentication_error",
+	"unauthorized",
+	"invalid api key",
+}
+
+// AuthError is returned by Session.Prompt and Turn.Err when the model provider
+// rejected the credentials the CLI used, e.g. because the API key is invalid
+// or expired, so that callers can ask for new ones instead of retrying.
+// errors.Is(err, ErrUnauthorized) reports whether err is an AuthError.
+type AuthError struct {
+	// Provider is the name of the provider in the Config given with
+	// WithConfig, or "" if it is not known.
+	Provider string
+	// Message is the error message reported by the CLI, including the
+	// provider's response.
+	Message string
+
+	err error
+}
+
+func (e *AuthError) Error() string {
+	if e.Provider == "" {
+		return "kimi: authentication failed: " + e.Message
+	}
+	return fmt.Sprintf("kimi: authentication with provider %q failed: %s", e.Provider, e.Message)
+}
+
+func (e *AuthError) Unwrap() []error {
+	return []error{ErrUnauthorized, e.err}
+}
+
+// providerError returns err, an error from the CLI's prompt method, as an
+// AuthError if the provider rejected the credentials, and unchanged
+// otherwise.
+func providerError(err error, provider string) error {
+	rpcErr, ok := jsonrpc2.ParseError(err)
+	if !ok || rpcErr.Code != errorCodeChatProvider {
+		return err
+	}
+	message := strings.ToLower(rpcErr.Message)
+	for _, marker := range authFailureMarkers {
+		if strings.Contains(message, marker) {
+			return &AuthError{Provider: provider, Message: rpcErr.Message, err: err}
+		}
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package kimi
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+)
+
+func TestProviderError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		auth bool
+	}{
+		{"Nil", nil, false},
+		{"401", rpc.ServerError(`{"code":-32003,"message":"Error code: 401 - {'error': {'message': 'Invalid Authentication', 'type': 'invalid_authentication_error'}}"}`), true},
+		{"InvalidAPIKey", rpc.ServerError(`{"code":-32003,"message":"Invalid API key provided"}`), true},
+		{"RateLimited", rpc.ServerError(`{"code":-32003,"message":"Error code: 429 - rate limit reached"}`), false},
+		{"ModelNotFound", rpc.ServerError(`{"code":-32003,"message":"Error code: 404 - model not found"}`), false},
+		{"OtherCode", rpc.ServerError(`{"code":-32000,"message":"Error code: 401"}`), false},
+		{"NotRPC", errors.New("Error code: 401"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := providerError(tt.err, "moonshot")
+			if got := errors.Is(err, ErrUnauthorized); got != tt.auth {
+				t.Fatalf("expected errors.Is(err, ErrUnauthorized) = %v, got %v for %v", tt.auth, got, err)
+			}
+			if !tt.auth {
+				if err != tt.err {
+					t.Fatalf("expected the error to be returned unchanged, got %v", err)
+				}
+				return
+			}
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				t.Fatalf("expected an AuthError, got %T", err)
+			}
+			if authErr.Provider != "moonshot" || authErr.Message == "" {
+				t.Errorf("unexpected AuthError %+v", authErr)
+			}
+			var serverErr rpc.ServerError
+			if !errors.As(err, &serverErr) {
+				t.Error("expected the JSON-RPC error to be wrapped")
+			}
+		})
+	}
+}
+
+func TestAuthError_Error(t *testing.T) {
+	err := &AuthError{Provider: "moonshot", Message: "Invalid Authentication"}
+	if got, want := err.Error(), `kimi: authentication with provider "moonshot" failed: Invalid Authentication`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	err.Provider = ""
+	if got, want := err.Error(), "kimi: authentication failed: Invalid Authentication"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
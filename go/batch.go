@@ -0,0 +1,101 @@
+package kimi
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// BatchResult is the outcome of one prompt run by PromptBatch.
+type BatchResult struct {
+	// Text is the text of the assistant's reply, as returned by Turn.Text.
+	Text string
+	// Result is the result of the turn, with a pending status if it never
+	// ran.
+	Result wire.PromptResult
+	// Usage is the usage of the turn.
+	Usage Usage
+	// Err is the error that ended the turn, or that kept it from starting.
+	Err error
+}
+
+// PromptBatch runs a prompt for each of contents, each in a session of its
+// own started with options, and returns their results in the order of
+// contents. At most concurrency sessions, and so CLI processes, run at a
+// time. Any WithSession in options is ignored: every prompt gets a fresh
+// session. As with Turn.Text, approval requests are rejected unless handled
+// by WithApprovalHandler or WithAutoApprove.
+//
+// Once ctx is done, no more prompts are started and the ones in flight are
+// cancelled; the results of prompts that did not run hold ctx's error. The
+// error returned joins the errors of all failed prompts, each prefixed with
+// its index, so one failure does not lose the other results.
+func PromptBatch(ctx context.Context, contents []wire.Content, concurrency int, options ...Option) ([]BatchResult, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("kimi: batch concurrency must be at least 1, got %d", concurrency)
+	}
+	options = append(slices.Clone(options), withoutSession())
+	results := make([]BatchResult, len(contents))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(contents)) {
+		wg.Go(func() {
+			for i := range next {
+				results[i] = promptBatchItem(ctx, contents[i], options)
+			}
+		})
+	}
+feed:
+	for i := range contents {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			for j := i; j < len(contents); j++ {
+				results[j] = BatchResult{
+					Result: wire.PromptResult{Status: wire.PromptResultStatusPending},
+					Err:    ctx.Err(),
+				}
+			}
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	var errs []error
+	for i, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("kimi: batch item %d: %w", i, result.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// promptBatchItem runs content in a new session and waits for the turn to
+// complete.
+func promptBatchItem(ctx context.Context, content wire.Content, options []Option) BatchResult {
+	result := BatchResult{Result: wire.PromptResult{Status: wire.PromptResultStatusPending}}
+	if result.Err = ctx.Err(); result.Err != nil {
+		return result
+	}
+	session, err := NewSessionContext(ctx, options...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	turn, err := session.Prompt(ctx, content)
+	if err != nil {
+		session.Close() //nolint:errcheck
+		result.Err = err
+		return result
+	}
+	result.Text, err = turn.Text(ctx)
+	result.Result = turn.Result()
+	result.Usage = *turn.Usage()
+	result.Err = cmp.Or(err, session.Close())
+	return result
+}
@@ -1,5 +1,27 @@
 package kimi
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrConflictingConfigOptions is returned by NewSession when more than
+	// one of WithConfig, WithConfigFromEnv and WithConfigFile is given, or
+	// one of them more than once.
+	ErrConflictingConfigOptions = errors.New("conflicting config options")
+)
+
+// ProviderType is the kind of API an LLMProvider speaks. The constants below
+// are the types the CLI supports; use ParseProviderType to check a type read
+// from user input.
 type ProviderType string
 
 const (
@@ -62,7 +84,65 @@ type MCPClientConfig struct {
 }
 
 type MCPConfig struct {
-	Client MCPClientConfig `json:"client" toml:"client"`
+	Client  MCPClientConfig            `json:"client" toml:"client"`
+	Servers map[string]MCPServerConfig `json:"mcpServers,omitempty" toml:"mcpServers,omitempty"`
+}
+
+type MCPTransport string
+
+const (
+	MCPTransportStdio MCPTransport = "stdio"
+	MCPTransportHTTP  MCPTransport = "http"
+	MCPTransportSSE   MCPTransport = "sse"
+)
+
+// MCPServerConfig describes an MCP server for the CLI to connect to: either a
+// command to run over stdio, or the URL of a server reached over HTTP or SSE.
+type MCPServerConfig struct {
+	Transport MCPTransport      `json:"transport,omitempty" toml:"transport,omitempty"`
+	Command   string            `json:"command,omitempty" toml:"command,omitempty"`
+	Args      []string          `json:"args,omitempty" toml:"args,omitempty"`
+	Env       map[string]string `json:"env,omitempty" toml:"env,omitempty"`
+	URL       string            `json:"url,omitempty" toml:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty" toml:"headers,omitempty"`
+}
+
+// MCPServerOption adds a server to the config built by NewMCPConfig.
+type MCPServerOption func(servers map[string]MCPServerConfig)
+
+// NewMCPConfig returns an MCPConfig with the given servers, for WithMCPConfig:
+//
+//	kimi.WithMCPConfig(kimi.NewMCPConfig(
+//		kimi.MCPStdioServer("git", "uvx", "mcp-server-git"),
+//		kimi.MCPHTTPServer("docs", "https://example.com/mcp", map[string]string{"Authorization": "Bearer ..."}),
+//	))
+//
+// A later server with the same name replaces an earlier one.
+func NewMCPConfig(servers ...MCPServerOption) *MCPConfig {
+	config := &MCPConfig{Servers: make(map[string]MCPServerConfig, len(servers))}
+	for _, add := range servers {
+		add(config.Servers)
+	}
+	return config
+}
+
+// MCPServer adds the server name as configured by server.
+func MCPServer(name string, server MCPServerConfig) MCPServerOption {
+	return func(servers map[string]MCPServerConfig) {
+		servers[name] = server
+	}
+}
+
+// MCPStdioServer adds a server that the CLI runs as command with args,
+// talking to it over stdio. Use MCPServer to also set its environment.
+func MCPStdioServer(name, command string, args ...string) MCPServerOption {
+	return MCPServer(name, MCPServerConfig{Transport: MCPTransportStdio, Command: command, Args: args})
+}
+
+// MCPHTTPServer adds a server reached over streamable HTTP at url, sending
+// headers with every request. headers may be nil.
+func MCPHTTPServer(name, url string, headers map[string]string) MCPServerOption {
+	return MCPServer(name, MCPServerConfig{Transport: MCPTransportHTTP, URL: url, Headers: headers})
 }
 
 type Config struct {
@@ -73,3 +153,194 @@ type Config struct {
 	Services     Services               `json:"services" toml:"services"`
 	MCP          MCPConfig              `json:"mcp" toml:"mcp"`
 }
+
+// Validate checks that c is complete enough for the CLI to load: the default
+// model and the provider of every model must be defined, every model must have
+// a max context size, and every provider a known type. It also runs
+// ValidateParams, and reports every problem found the same way. NewSession
+// calls it for a config given with WithConfig or WithConfigFromEnv.
+func (c *Config) Validate() error {
+	var problems []error
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+	if c.DefaultModel != "" {
+		if _, ok := c.Models[c.DefaultModel]; !ok {
+			add("default_model: model %q is not defined in models", c.DefaultModel)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Models)) {
+		model := c.Models[name]
+		if model.Provider == "" {
+			add("models[%q].provider: must be set", name)
+		} else if _, ok := c.Providers[model.Provider]; !ok {
+			add("models[%q].provider: provider %q is not defined in providers", name, model.Provider)
+		}
+		if model.MaxContextSize == 0 {
+			add("models[%q].max_context_size: must be set", name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Providers)) {
+		if t := c.Providers[name].Type; !t.IsValid() {
+			add("providers[%q].type: %w", name, unknownProviderType(t))
+		}
+	}
+	problems = append(problems, c.paramProblems()...)
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n%w", ErrInvalidConfig, errors.Join(problems...))
+	}
+	return nil
+}
+
+// ValidateParams checks the settings of c for values the CLI or the provider
+// would reject: negative limits and timeouts, and base URLs that are missing
+// or malformed. Every problem found is reported, each prefixed with the path of
+// the offending field, e.g. providers["kimi"].base_url. The error wraps
+// ErrInvalidConfig.
+func (c *Config) ValidateParams() error {
+	if problems := c.paramProblems(); len(problems) > 0 {
+		return fmt.Errorf("%w:\n%w", ErrInvalidConfig, errors.Join(problems...))
+	}
+	return nil
+}
+
+func (c *Config) paramProblems() []error {
+	var problems []error
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Providers)) {
+		provider := c.Providers[name]
+		switch {
+		case provider.BaseURL != "":
+			if u, err := url.Parse(provider.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("providers[%q].base_url: %q is not an http or https URL", name, provider.BaseURL)
+			}
+		case !provider.Type.defaultsBaseURL():
+			add("providers[%q].base_url: must be set for provider type %q", name, provider.Type)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Models)) {
+		if size := c.Models[name].MaxContextSize; size < 0 {
+			add("models[%q].max_context_size: must not be negative, got %d", name, size)
+		}
+	}
+	if n := c.LoopControl.MaxStepsPerRun; n < 0 {
+		add("loop_control.max_steps_per_run: must not be negative, got %d", n)
+	}
+	if n := c.LoopControl.MaxRetriesPerStep; n < 0 {
+		add("loop_control.max_retries_per_step: must not be negative, got %d", n)
+	}
+	if ms := c.MCP.Client.ToolCallTimeoutMS; ms < 0 {
+		add("mcp.client.tool_call_timeout_ms: must not be negative, got %d", ms)
+	}
+	return problems
+}
+
+var providerTypes = []ProviderType{
+	ProviderTypeKimi,
+	ProviderTypeOpenAILegacy,
+	ProviderTypeOpenAIResponses,
+	ProviderTypeAnthropic,
+	ProviderTypeGoogleGenAI,
+	ProviderTypeGemini,
+	ProviderTypeVertexAI,
+}
+
+// ProviderTypes returns the provider types the CLI supports.
+func ProviderTypes() []ProviderType {
+	return slices.Clone(providerTypes)
+}
+
+// ParseProviderType returns the provider type named s, ignoring case and
+// surrounding space. The error wraps ErrInvalidConfig and lists the supported
+// types if s names none of them.
+func ParseProviderType(s string) (ProviderType, error) {
+	t := ProviderType(strings.ToLower(strings.TrimSpace(s)))
+	if !t.IsValid() {
+		return "", fmt.Errorf("%w: %w", ErrInvalidConfig, unknownProviderType(ProviderType(s)))
+	}
+	return t, nil
+}
+
+// IsValid reports whether t is one of the provider types the CLI supports.
+func (t ProviderType) IsValid() bool {
+	return slices.Contains(providerTypes, t)
+}
+
+func unknownProviderType(t ProviderType) error {
+	names := make([]string, len(providerTypes))
+	for i, t := range providerTypes {
+		names[i] = string(t)
+	}
+	return fmt.Errorf("unknown provider type %q, expected one of %s", t, strings.Join(names, ", "))
+}
+
+// defaultsBaseURL reports whether providers of type t work without a base
+// URL, because their client has a default endpoint.
+func (t ProviderType) defaultsBaseURL() bool {
+	switch t {
+	case ProviderTypeGoogleGenAI, ProviderTypeGemini, ProviderTypeVertexAI:
+		return true
+	}
+	return false
+}
+
+// supports reports whether the model identified by name (or the default model
+// when name is empty) has the given capability. known is false when the model
+// is not present in the config.
+func (c *Config) supports(name string, capability ModelCapability) (supported, known bool) {
+	if c == nil {
+		return false, false
+	}
+	if name == "" {
+		name = c.DefaultModel
+	}
+	model, ok := c.Models[name]
+	if !ok {
+		return false, false
+	}
+	return model.Capabilities[capability], true
+}
+
+// provider returns the name of the provider of the model name, or of the
+// default model if name is "", or "" if c does not configure it.
+func (c *Config) provider(name string) string {
+	if c == nil {
+		return ""
+	}
+	if name == "" {
+		name = c.DefaultModel
+	}
+	return c.Models[name].Provider
+}
+
+// maxContextSize returns the MaxContextSize of the model name, or of the
+// default model if name is "", or 0 if it is not known.
+func (c *Config) maxContextSize(name string) int {
+	if c == nil {
+		return 0
+	}
+	if name == "" {
+		name = c.DefaultModel
+	}
+	return c.Models[name].MaxContextSize
+}
+
+// configFromEnv decodes the JSON config held by the environment variable name.
+func configFromEnv(name string) (*Config, error) {
+	data, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("config environment variable %s is not set", name)
+	}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("config environment variable %s: %w", name, err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("config environment variable %s: unexpected data after the config", name)
+	}
+	return &config, nil
+}
@@ -2,6 +2,9 @@ package kimi
 
 import (
 	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -215,3 +218,242 @@ func TestConfig_EmptyFields(t *testing.T) {
 		t.Errorf("Providers should be empty")
 	}
 }
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("KIMI_TEST_CONFIG", `{"default_model":"k2","models":{"k2":{"provider":"kimi","model":"kimi-k2","max_context_size":1000}}}`)
+	config, err := configFromEnv("KIMI_TEST_CONFIG")
+	if err != nil {
+		t.Fatalf("configFromEnv: %v", err)
+	}
+	if config.DefaultModel != "k2" || config.Models["k2"].Model != "kimi-k2" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestConfigFromEnv_Invalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		value *string
+	}{
+		{"Unset", nil},
+		{"Empty", ptr("")},
+		{"Malformed", ptr(`{"default_model":`)},
+		{"UnknownField", ptr(`{"default_modle":"k2"}`)},
+		{"TrailingData", ptr(`{} {}`)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.value != nil {
+				t.Setenv("KIMI_TEST_CONFIG", *tc.value)
+			}
+			_, err := configFromEnv("KIMI_TEST_CONFIG")
+			if err == nil || !strings.Contains(err.Error(), "KIMI_TEST_CONFIG") {
+				t.Fatalf("expected an error naming the variable, got %v", err)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestNewMCPConfig_JSON(t *testing.T) {
+	config := NewMCPConfig(
+		MCPStdioServer("git", "uvx", "mcp-server-git"),
+		MCPHTTPServer("docs", "https://example.com/mcp", map[string]string{"Authorization": "Bearer token"}),
+		MCPServer("events", MCPServerConfig{Transport: MCPTransportSSE, URL: "https://example.com/sse"}),
+		MCPServer("local", MCPServerConfig{Command: "node", Args: []string{"server.js"}, Env: map[string]string{"DEBUG": "1"}}),
+	)
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var raw struct {
+		Servers map[string]map[string]any `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got := raw.Servers["git"]; got["transport"] != "stdio" || got["command"] != "uvx" || got["url"] != nil {
+		t.Errorf("unexpected stdio server JSON %v", got)
+	}
+	if got := raw.Servers["docs"]; got["transport"] != "http" || got["url"] != "https://example.com/mcp" || got["command"] != nil {
+		t.Errorf("unexpected http server JSON %v", got)
+	}
+
+	var parsed MCPConfig
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(&parsed, config) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", &parsed, config)
+	}
+}
+
+func TestMCPConfig_NoServers(t *testing.T) {
+	data, err := json.Marshal(&MCPConfig{Client: MCPClientConfig{ToolCallTimeoutMS: 1000}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Contains(string(data), "mcpServers") {
+		t.Errorf("expected mcpServers to be omitted, got %s", data)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			DefaultModel: "k2",
+			Models:       map[string]LLMModel{"k2": {Provider: "kimi", Model: "kimi-k2", MaxContextSize: 128000}},
+			Providers:    map[string]LLMProvider{"kimi": {Type: ProviderTypeKimi, BaseURL: "https://api.moonshot.cn/v1"}},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"no default model", func(c *Config) { c.DefaultModel = "" }, nil},
+		{"undefined default model", func(c *Config) {
+			c.DefaultModel = "k3"
+		}, []string{`default_model: model "k3" is not defined in models`}},
+		{"missing provider", func(c *Config) {
+			c.Models["k2"] = LLMModel{Model: "kimi-k2", MaxContextSize: 128000}
+		}, []string{`models["k2"].provider: must be set`}},
+		{"undefined provider", func(c *Config) {
+			c.Models["k2"] = LLMModel{Provider: "moonshot", Model: "kimi-k2", MaxContextSize: 128000}
+		}, []string{`models["k2"].provider: provider "moonshot" is not defined in providers`}},
+		{"missing max context size", func(c *Config) {
+			c.Models["k2"] = LLMModel{Provider: "kimi", Model: "kimi-k2"}
+		}, []string{`models["k2"].max_context_size: must be set`}},
+		{"unknown provider type", func(c *Config) {
+			c.Providers["kimi"] = LLMProvider{Type: "moonshot", BaseURL: "https://api.moonshot.cn/v1"}
+		}, []string{`providers["kimi"].type: unknown provider type "moonshot"`}},
+		{"invalid params", func(c *Config) {
+			c.LoopControl.MaxStepsPerRun = -1
+		}, []string{`loop_control.max_steps_per_run: must not be negative, got -1`}},
+		{"several problems", func(c *Config) {
+			c.DefaultModel = "k3"
+			c.Providers["kimi"] = LLMProvider{Type: "moonshot"}
+		}, []string{
+			`default_model: model "k3" is not defined in models`,
+			`providers["kimi"].type: unknown provider type "moonshot"`,
+			`providers["kimi"].base_url: must be set`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid()
+			tt.modify(config)
+			err := config.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateParams(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			DefaultModel: "k2",
+			Models:       map[string]LLMModel{"k2": {Provider: "kimi", Model: "kimi-k2", MaxContextSize: 128000}},
+			Providers: map[string]LLMProvider{
+				"kimi":   {Type: ProviderTypeKimi, BaseURL: "https://api.moonshot.cn/v1"},
+				"gemini": {Type: ProviderTypeGemini},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"empty base URL", func(c *Config) {
+			c.Providers["kimi"] = LLMProvider{Type: ProviderTypeKimi}
+		}, []string{`providers["kimi"].base_url: must be set`}},
+		{"malformed base URL", func(c *Config) {
+			c.Providers["kimi"] = LLMProvider{Type: ProviderTypeKimi, BaseURL: "api.moonshot.cn/v1"}
+		}, []string{`providers["kimi"].base_url: "api.moonshot.cn/v1" is not an http or https URL`}},
+		{"negative limits", func(c *Config) {
+			c.Models["k2"] = LLMModel{Provider: "kimi", Model: "kimi-k2", MaxContextSize: -1}
+			c.LoopControl = LoopControl{MaxStepsPerRun: -1, MaxRetriesPerStep: -2}
+			c.MCP.Client.ToolCallTimeoutMS = -100
+		}, []string{
+			`models["k2"].max_context_size: must not be negative, got -1`,
+			`loop_control.max_steps_per_run: must not be negative, got -1`,
+			`loop_control.max_retries_per_step: must not be negative, got -2`,
+			`mcp.client.tool_call_timeout_ms: must not be negative, got -100`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid()
+			tt.modify(config)
+			err := config.ValidateParams()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("ValidateParams: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProviderType(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want ProviderType
+	}{
+		{"kimi", ProviderTypeKimi},
+		{"Anthropic", ProviderTypeAnthropic},
+		{" openai_responses\n", ProviderTypeOpenAIResponses},
+		{"google_genai", ProviderTypeGoogleGenAI},
+	} {
+		got, err := ParseProviderType(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseProviderType(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "moonshot", "openai"} {
+		_, err := ParseProviderType(in)
+		if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "expected one of kimi, openai_legacy") {
+			t.Errorf("ParseProviderType(%q): expected an ErrInvalidConfig listing the types, got %v", in, err)
+		}
+	}
+}
+
+func TestProviderType_IsValid(t *testing.T) {
+	for _, pt := range ProviderTypes() {
+		if !pt.IsValid() {
+			t.Errorf("expected %q to be valid", pt)
+		}
+	}
+	if ProviderType("Kimi").IsValid() {
+		t.Error("expected IsValid to be case sensitive")
+	}
+}
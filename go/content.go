@@ -0,0 +1,134 @@
+package kimi
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// ContentFromFS reads the file at name from fsys (e.g. an embed.FS) and
+// returns it as prompt content, so embedded assets can be attached without
+// writing them to disk first. As with all fs.FS paths, name is slash-separated.
+//
+// Text files become string content. Images, audio and video become a single
+// content part carrying a base64 data URL.
+func ContentFromFS(fsys fs.FS, name string) (wire.Content, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return wire.Content{}, err
+	}
+	return contentFromData(name, data)
+}
+
+// ContentFromReader reads r to the end and returns it as prompt content like
+// ContentFromFS, e.g. to attach a diff or a log piped into the program. name
+// is only used to tell the content type by its extension, and in errors; with
+// an empty name the type is detected from the data.
+//
+// The CLI's stdin carries the wire protocol, so input cannot be streamed to it
+// directly; the content is sent as part of the prompt instead.
+func ContentFromReader(name string, r io.Reader) (wire.Content, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return wire.Content{}, err
+	}
+	return contentFromData(name, data)
+}
+
+// ContentPartFromFile reads the file at name and returns it as a single
+// content part, to be combined with others by wire.NewContent, e.g. a question
+// and the screenshot it is about:
+//
+//	image, err := kimi.ContentPartFromFile("screenshot.png")
+//	...
+//	content := wire.NewContent(wire.NewTextContentPart("What is wrong here?"), image)
+//
+// The type is told as by ContentFromFS: text files become a text part, and
+// images, audio and video a part carrying a base64 data URL.
+func ContentPartFromFile(name string) (wire.ContentPart, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return wire.ContentPart{}, err
+	}
+	content, err := contentFromData(name, data)
+	if err != nil {
+		return wire.ContentPart{}, err
+	}
+	if content.Type == wire.ContentTypeText {
+		return wire.NewTextContentPart(content.Text.Value), nil
+	}
+	return content.ContentParts.Value[0], nil
+}
+
+func contentFromData(name string, data []byte) (wire.Content, error) {
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	dataURL := func() string {
+		return wire.DataURL(mediaType, data)
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return wire.NewContent(wire.NewImageContentPart(dataURL())), nil
+	case strings.HasPrefix(mediaType, "audio/"):
+		return wire.NewContent(wire.NewAudioContentPart(dataURL())), nil
+	case strings.HasPrefix(mediaType, "video/"):
+		return wire.NewContent(wire.NewVideoContentPart(dataURL())), nil
+	case strings.HasPrefix(mediaType, "text/") || utf8.Valid(data):
+		return wire.NewStringContent(string(data)), nil
+	default:
+		return wire.Content{}, fmt.Errorf("%s: unsupported content type %q", cmp.Or(name, "input"), mediaType)
+	}
+}
+
+type contextFiles struct {
+	fsys  fs.FS
+	paths []string
+}
+
+// readContextFiles reads the files for WithContextFilesFS as content parts.
+// Text files are preceded by their path so the model can tell them apart.
+func readContextFiles(files []contextFiles) ([]wire.ContentPart, error) {
+	var parts []wire.ContentPart
+	for _, f := range files {
+		for _, name := range f.paths {
+			content, err := ContentFromFS(f.fsys, name)
+			if err != nil {
+				return nil, err
+			}
+			if content.Type == wire.ContentTypeText {
+				parts = append(parts, wire.NewTextContentPart(name+":\n"+content.Text.Value))
+				continue
+			}
+			parts = append(parts, content.ContentParts.Value...)
+		}
+	}
+	return parts, nil
+}
+
+// withContextParts returns content with parts prepended to it.
+func withContextParts(parts []wire.ContentPart, content wire.Content) wire.Content {
+	if len(parts) == 0 {
+		return content
+	}
+	all := slices.Clone(parts)
+	switch content.Type {
+	case wire.ContentTypeText:
+		all = append(all, wire.NewTextContentPart(content.Text.Value))
+	case wire.ContentTypeContentParts:
+		all = append(all, content.ContentParts.Value...)
+	}
+	return wire.NewContent(all...)
+}
@@ -0,0 +1,170 @@
+package kimi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestContentFromFS_Text(t *testing.T) {
+	fsys := fstest.MapFS{
+		"prompts/review.md": {Data: []byte("Review the diff.")},
+	}
+
+	content, err := ContentFromFS(fsys, "prompts/review.md")
+	if err != nil {
+		t.Fatalf("ContentFromFS: %v", err)
+	}
+	if content.Type != wire.ContentTypeText {
+		t.Fatalf("expected text content, got %s", content.Type)
+	}
+	if content.Text.Value != "Review the diff." {
+		t.Errorf("expected file text, got %q", content.Text.Value)
+	}
+}
+
+func TestContentFromFS_Image(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/logo": {Data: pngHeader},
+	}
+
+	content, err := ContentFromFS(fsys, "assets/logo")
+	if err != nil {
+		t.Fatalf("ContentFromFS: %v", err)
+	}
+	if content.Type != wire.ContentTypeContentParts || len(content.ContentParts.Value) != 1 {
+		t.Fatalf("expected a single content part, got %+v", content)
+	}
+	part := content.ContentParts.Value[0]
+	if part.Type != wire.ContentPartTypeImageURL {
+		t.Fatalf("expected image_url part, got %s", part.Type)
+	}
+	want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngHeader)
+	if part.ImageURL.Value.URL != want {
+		t.Errorf("expected data URL %q, got %q", want, part.ImageURL.Value.URL)
+	}
+}
+
+func TestContentFromFS_Binary(t *testing.T) {
+	fsys := fstest.MapFS{
+		"blob": {Data: []byte{0x00, 0xff, 0xfe, 0x01}},
+	}
+
+	_, err := ContentFromFS(fsys, "blob")
+	if err == nil || !strings.Contains(err.Error(), "unsupported content type") {
+		t.Fatalf("expected unsupported content type error, got %v", err)
+	}
+}
+
+func TestContentFromFS_NotExist(t *testing.T) {
+	_, err := ContentFromFS(fstest.MapFS{}, "missing.txt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestContentPartFromFile(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "screenshot.png")
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(image, pngHeader, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(text, []byte("It crashes on start."), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	part, err := ContentPartFromFile(image)
+	if err != nil {
+		t.Fatalf("ContentPartFromFile: %v", err)
+	}
+	if want := wire.NewImageDataContentPart("image/png", pngHeader); !reflect.DeepEqual(part, want) {
+		t.Errorf("expected %+v, got %+v", want, part)
+	}
+	part, err = ContentPartFromFile(text)
+	if err != nil {
+		t.Fatalf("ContentPartFromFile: %v", err)
+	}
+	if want := wire.NewTextContentPart("It crashes on start."); !reflect.DeepEqual(part, want) {
+		t.Errorf("expected %+v, got %+v", want, part)
+	}
+	if _, err := ContentPartFromFile(filepath.Join(dir, "missing.png")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestContentFromReader(t *testing.T) {
+	content, err := ContentFromReader("changes.diff", strings.NewReader("--- a/main.go\n+++ b/main.go\n"))
+	if err != nil {
+		t.Fatalf("ContentFromReader: %v", err)
+	}
+	if content.Type != wire.ContentTypeText || content.Text.Value != "--- a/main.go\n+++ b/main.go\n" {
+		t.Errorf("expected the diff as text content, got %+v", content)
+	}
+
+	// Without a name the type is detected from the data.
+	content, err = ContentFromReader("", bytes.NewReader(pngHeader))
+	if err != nil {
+		t.Fatalf("ContentFromReader: %v", err)
+	}
+	if len(content.ContentParts.Value) != 1 || content.ContentParts.Value[0].Type != wire.ContentPartTypeImageURL {
+		t.Errorf("expected an image part, got %+v", content)
+	}
+
+	if _, err := ContentFromReader("", bytes.NewReader([]byte{0x00, 0xff, 0xfe, 0x01})); err == nil || !strings.HasPrefix(err.Error(), "input:") {
+		t.Errorf("expected an unsupported content error for the input, got %v", err)
+	}
+}
+
+func TestReadContextFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"context/style.md": {Data: []byte("Use tabs.")},
+		"context/logo.png": {Data: pngHeader},
+	}
+
+	parts, err := readContextFiles([]contextFiles{{fsys, []string{"context/style.md", "context/logo.png"}}})
+	if err != nil {
+		t.Fatalf("readContextFiles: %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+	if parts[0].Text.Value != "context/style.md:\nUse tabs." {
+		t.Errorf("expected text part with path header, got %q", parts[0].Text.Value)
+	}
+	if parts[1].Type != wire.ContentPartTypeImageURL {
+		t.Errorf("expected image part, got %s", parts[1].Type)
+	}
+
+	if _, err := readContextFiles([]contextFiles{{fsys, []string{"missing.md"}}}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestWithContextParts(t *testing.T) {
+	parts := []wire.ContentPart{wire.NewTextContentPart("context")}
+
+	got := withContextParts(parts, wire.NewStringContent("prompt"))
+	if got.Type != wire.ContentTypeContentParts || len(got.ContentParts.Value) != 2 {
+		t.Fatalf("expected 2 content parts, got %+v", got)
+	}
+	if got.ContentParts.Value[0].Text.Value != "context" || got.ContentParts.Value[1].Text.Value != "prompt" {
+		t.Errorf("expected context before prompt, got %+v", got.ContentParts.Value)
+	}
+
+	plain := wire.NewStringContent("prompt")
+	if got := withContextParts(nil, plain); !reflect.DeepEqual(got, plain) {
+		t.Errorf("expected content unchanged without context files, got %+v", got)
+	}
+}
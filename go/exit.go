@@ -0,0 +1,146 @@
+package kimi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrExecutableNotFound = errors.New("kimi executable not found")
+)
+
+const (
+	stderrTailSize  = 4 << 10
+	stderrTailLines = 20
+)
+
+// ExitError is returned by NewSession, Session.Prompt and Session.Close when
+// the CLI subprocess could not be started, exited with a non-zero status, or
+// was killed by a signal the SDK did not send. Use errors.As to inspect it;
+// errors.Is(err, ErrExecutableNotFound) reports whether the executable is
+// missing.
+type ExitError struct {
+	// ExitCode is the exit status of the CLI, or -1 if it did not start or
+	// was killed by a signal.
+	ExitCode int
+	// Stderr holds the last lines the CLI wrote to its standard error.
+	Stderr string
+	// NotFound is set when the executable does not exist or is not on PATH.
+	NotFound bool
+
+	// startFailed is set when the executable could not be started.
+	startFailed bool
+	err         error
+}
+
+func (e *ExitError) Error() string {
+	var msg string
+	if e.NotFound || e.startFailed {
+		msg = "kimi: " + e.err.Error()
+	} else {
+		msg = fmt.Sprintf("kimi: CLI exited: %v", e.err)
+	}
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *ExitError) Unwrap() []error {
+	if e.NotFound {
+		return []error{ErrExecutableNotFound, e.err}
+	}
+	return []error{e.err}
+}
+
+// startError wraps an error from exec.Cmd.Start in an ExitError, flagging a
+// missing executable. An error caused by the startup context being done is
+// returned unchanged.
+func startError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	notFound := errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+	return &ExitError{ExitCode: -1, NotFound: notFound, startFailed: true, err: err}
+}
+
+// exitError returns an ExitError for state if the process exited with a
+// non-zero status, or was killed by a signal while killed, which reports
+// whether the SDK stopped it, is false. It returns nil otherwise.
+func exitError(state *os.ProcessState, stderr *stderrTail, killed bool) error {
+	if state == nil || state.ExitCode() == 0 || state.ExitCode() < 0 && killed {
+		return nil
+	}
+	return &ExitError{
+		ExitCode: state.ExitCode(),
+		Stderr:   stderr.String(),
+		err:      errors.New(state.String()),
+	}
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// stderrTail keeps the last stderrTailSize bytes written to it, and copies
+// everything to tee, if set.
+type stderrTail struct {
+	mu        sync.Mutex
+	buf       []byte
+	truncated bool
+
+	tee io.Writer
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*stderrTailSize {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-stderrTailSize:]...)
+		t.truncated = true
+	}
+	t.mu.Unlock()
+	if t.tee != nil {
+		// A failing tee must not stop the copying of stderr, or the CLI
+		// would block once the pipe is full.
+		t.tee.Write(p) //nolint:errcheck
+	}
+	return len(p), nil
+}
+
+// String returns up to the last stderrTailLines complete lines.
+func (t *stderrTail) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.buf
+	truncated := t.truncated
+	if len(b) > stderrTailSize {
+		b = b[len(b)-stderrTailSize:]
+		truncated = true
+	}
+	s := string(b)
+	if truncated {
+		// Drop the partial line at the start.
+		if _, rest, ok := strings.Cut(s, "\n"); ok {
+			s = rest
+		}
+	}
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	if len(lines) > stderrTailLines {
+		lines = lines[len(lines)-stderrTailLines:]
+	}
+	return strings.Join(lines, "\n")
+}
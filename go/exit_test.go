@@ -0,0 +1,111 @@
+package kimi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewSession_ExecutableNotFound(t *testing.T) {
+	for _, executable := range []string{"kimi-does-not-exist", filepath.Join(t.TempDir(), "kimi")} {
+		_, err := NewSession(WithExecutable(executable))
+		if !errors.Is(err, ErrExecutableNotFound) {
+			t.Fatalf("WithExecutable(%q): expected ErrExecutableNotFound, got %v", executable, err)
+		}
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) || !exitErr.NotFound {
+			t.Fatalf("WithExecutable(%q): expected an ExitError with NotFound, got %#v", executable, err)
+		}
+	}
+}
+
+func TestNewSession_StartFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes do not make a file unexecutable on Windows")
+	}
+	executable := filepath.Join(t.TempDir(), "kimi")
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewSession(WithExecutable(executable))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an ExitError, got %#v", err)
+	}
+	if exitErr.ExitCode != -1 || exitErr.NotFound || errors.Is(err, ErrExecutableNotFound) {
+		t.Errorf("expected a start failure other than a missing executable, got %+v", exitErr)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected the error to wrap the permission error, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "kimi: ") || strings.Contains(err.Error(), "CLI exited") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+func TestStartError_Context(t *testing.T) {
+	if err := startError(context.Canceled); err != context.Canceled {
+		t.Errorf("expected a context error to be returned unchanged, got %#v", err)
+	}
+}
+
+func TestStderrTail(t *testing.T) {
+	tail := new(stderrTail)
+	for i := range 30 {
+		fmt.Fprintf(tail, "line %d\n", i)
+	}
+	got := strings.Split(tail.String(), "\n")
+	if len(got) != stderrTailLines || got[0] != "line 10" || got[len(got)-1] != "line 29" {
+		t.Fatalf("expected the last %d lines, got %q", stderrTailLines, got)
+	}
+}
+
+func TestStderrTail_Truncated(t *testing.T) {
+	tail := new(stderrTail)
+	tail.Write([]byte(strings.Repeat("x", 3*stderrTailSize) + "\nlast\n"))
+	if got := tail.String(); got != "last" {
+		t.Fatalf("expected the partial first line to be dropped, got %q", got)
+	}
+}
+
+func TestStderrTail_Tee(t *testing.T) {
+	var tee strings.Builder
+	tail := &stderrTail{tee: &tee}
+	tail.Write([]byte(strings.Repeat("x", 3*stderrTailSize) + "\nlast\n"))
+	if got := tail.String(); got != "last" {
+		t.Fatalf("expected the tail to be kept, got %q", got)
+	}
+	if tee.Len() != 3*stderrTailSize+6 {
+		t.Fatalf("expected everything to be copied, got %d bytes", tee.Len())
+	}
+}
+
+func TestStderrTail_TeeError(t *testing.T) {
+	tail := &stderrTail{tee: errWriter{}}
+	if n, err := tail.Write([]byte("boom\n")); n != 5 || err != nil {
+		t.Fatalf("expected the tee error to be ignored, got %d, %v", n, err)
+	}
+	if got := tail.String(); got != "boom" {
+		t.Fatalf("expected the tail to be kept, got %q", got)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("closed") }
+
+func TestExitError(t *testing.T) {
+	err := &ExitError{ExitCode: 2, Stderr: "loading\nerror: bad key", err: errors.New("exit status 2")}
+	if got, want := err.Error(), "kimi: CLI exited: exit status 2: error: bad key"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if errors.Is(err, ErrExecutableNotFound) {
+		t.Error("expected a non-NotFound ExitError not to match ErrExecutableNotFound")
+	}
+}
@@ -0,0 +1,82 @@
+package kimi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// Healthy checks that the session can still run prompts, without starting a
+// turn: that the CLI process is running and answers on the wire. It returns
+// nil if so, and an error matching ErrSessionDead if the CLI has exited, the
+// pipe to it is broken, or it does not answer before ctx is done, so a pool
+// can evict the session and start a new one. If ctx is already done, Healthy
+// returns its error instead.
+//
+// The probe is a cancel request, which the CLI answers, successfully or not,
+// without side effects when no turn is running. While a turn is in progress
+// the CLI is not probed, as that would cancel it; Healthy then only checks
+// that the process is running. A Prompt issued during the probe waits for it
+// to finish.
+func (s *Session) Healthy(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.exited(); err != nil {
+		return err
+	}
+	select {
+	case s.probing <- struct{}{}:
+	case <-s.ctx.Done():
+		return s.exited()
+	case <-ctx.Done():
+		return fmt.Errorf("%w: CLI did not answer: %w", ErrSessionDead, ctx.Err())
+	}
+	s.rwlock.RLock()
+	busy := s.wireMessageBridge != nil
+	s.rwlock.RUnlock()
+	if busy {
+		<-s.probing
+		return nil
+	}
+	answered := make(chan error, 1)
+	go func() {
+		// Hold off new turns until the CLI has answered.
+		defer func() { <-s.probing }()
+		_, err := s.tp.Cancel(&wire.CancelParams{})
+		answered <- err
+	}()
+	select {
+	case err := <-answered:
+		// An error response still shows the CLI is alive.
+		var serverErr rpc.ServerError
+		if err != nil && !errors.As(err, &serverErr) {
+			if exitErr := s.exited(); exitErr != nil {
+				return exitErr
+			}
+			return fmt.Errorf("%w: %w", ErrSessionDead, err)
+		}
+		return nil
+	case <-s.ctx.Done():
+		return s.exited()
+	case <-ctx.Done():
+		return fmt.Errorf("%w: CLI did not answer: %w", ErrSessionDead, ctx.Err())
+	}
+}
+
+// exited returns an ErrSessionDead error if the CLI process has exited, and
+// nil otherwise.
+func (s *Session) exited() error {
+	select {
+	case <-s.ctx.Done():
+	default:
+		return nil
+	}
+	if err := exitError(s.cmd.ProcessState, s.stderr, s.killed.Load()); err != nil {
+		return fmt.Errorf("%w: %w", ErrSessionDead, err)
+	}
+	return fmt.Errorf("%w: CLI has exited", ErrSessionDead)
+}
@@ -0,0 +1,83 @@
+package kimi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// promptHistoryMaxSize is the size at which a prompt history file is rotated
+// to "<path>.1", replacing any previous rotation.
+const promptHistoryMaxSize = 1 << 20
+
+// promptHistoryLocks serializes writers of the same history file across all
+// sessions in the process, keyed by absolute path.
+var promptHistoryLocks sync.Map
+
+type promptHistory struct {
+	path string
+	mu   *sync.Mutex
+}
+
+func newPromptHistory(path string) *promptHistory {
+	if path == "" {
+		return nil
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	mu, _ := promptHistoryLocks.LoadOrStore(path, new(sync.Mutex))
+	return &promptHistory{path: path, mu: mu.(*sync.Mutex)}
+}
+
+// append writes content as a single history entry, rotating the file first if
+// it has reached promptHistoryMaxSize.
+func (h *promptHistory) append(content wire.Content) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if info, err := os.Stat(h.path); err == nil && info.Size() >= promptHistoryMaxSize {
+		if err := os.Rename(h.path, h.path+".1"); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(promptHistoryEntry(content) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var promptHistoryEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// promptHistoryEntry renders content as one line: text with backslashes and
+// line breaks escaped, and attachments replaced by placeholders such as
+// "[image]".
+func promptHistoryEntry(content wire.Content) string {
+	if content.Type == wire.ContentTypeText {
+		return promptHistoryEscaper.Replace(content.Text.Value)
+	}
+	var parts []string
+	for _, part := range content.ContentParts.Value {
+		switch part.Type {
+		case wire.ContentPartTypeText:
+			parts = append(parts, promptHistoryEscaper.Replace(part.Text.Value))
+		case wire.ContentPartTypeImageURL:
+			parts = append(parts, "[image]")
+		case wire.ContentPartTypeAudioURL:
+			parts = append(parts, "[audio]")
+		case wire.ContentPartTypeVideoURL:
+			parts = append(parts, "[video]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
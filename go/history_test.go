@@ -0,0 +1,110 @@
+package kimi
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+func TestPromptHistoryEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		content wire.Content
+		want    string
+	}{
+		{"text", wire.NewStringContent("hello"), "hello"},
+		{"escaped", wire.NewStringContent("a\\b\nc\r"), `a\\b\nc\r`},
+		{"parts", wire.NewContent(
+			wire.NewTextContentPart("describe"),
+			wire.NewImageContentPart("data:image/png;base64,AAAA"),
+			wire.NewAudioContentPart("https://example.com/a.mp3"),
+			wire.NewVideoContentPart("https://example.com/v.mp4"),
+		), "describe [image] [audio] [video]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := promptHistoryEntry(tt.content); got != tt.want {
+				t.Errorf("promptHistoryEntry() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptHistory_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := newPromptHistory(path)
+	for _, text := range []string{"first", "second"} {
+		if err := h.append(wire.NewStringContent(text)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "first\nsecond\n" {
+		t.Errorf("history = %q", data)
+	}
+}
+
+func TestPromptHistory_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	old := bytes.Repeat([]byte("x\n"), promptHistoryMaxSize/2)
+	if err := os.WriteFile(path, old, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := newPromptHistory(path).append(wire.NewStringContent("fresh")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "fresh\n" {
+		t.Errorf("history = %q, want only the new entry", data)
+	}
+	if data, _ := os.ReadFile(path + ".1"); !bytes.Equal(data, old) {
+		t.Errorf("rotated history has %d bytes, want %d", len(data), len(old))
+	}
+}
+
+func TestPromptHistory_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	entry := strings.Repeat("y", 4096)
+	var wg sync.WaitGroup
+	for range 8 {
+		h := newPromptHistory(path)
+		wg.Go(func() {
+			for range 10 {
+				if err := h.append(wire.NewStringContent(entry)); err != nil {
+					t.Errorf("append: %v", err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 80 {
+		t.Fatalf("got %d entries, want 80", len(lines))
+	}
+	for i, line := range lines {
+		if line != entry {
+			t.Fatalf("entry %d is interleaved", i)
+		}
+	}
+}
+
+func TestPromptHistory_Nil(t *testing.T) {
+	if h := newPromptHistory(""); h != nil {
+		t.Fatal("expected nil history for empty path")
+	}
+	var h *promptHistory
+	if err := h.append(wire.NewStringContent("ignored")); err != nil {
+		t.Fatalf("append on nil history: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package kimitest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	kimi "github.com/MoonshotAI/kimi-agent-sdk/go"
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// fakeCLIEnv holds the script of the fake CLI started by NewFakeSession.
+const fakeCLIEnv = "KIMITEST_FAKE_CLI"
+
+// fakeWireProtocolVersion is the wire protocol version the fake CLI speaks.
+const fakeWireProtocolVersion = "1.7"
+
+var mainCalled bool
+
+// Main runs the fake CLI when the test binary was started as one by
+// NewFakeSession, and the tests otherwise. Call it from TestMain in every
+// package that uses NewFakeSession:
+//
+//	func TestMain(m *testing.M) {
+//		kimitest.Main(m)
+//	}
+func Main(m *testing.M) {
+	if script, ok := os.LookupEnv(fakeCLIEnv); ok {
+		os.Exit(runFakeCLI(script, os.Args[1:], os.Stdin, os.Stdout))
+	}
+	mainCalled = true
+	os.Exit(m.Run())
+}
+
+// NewFakeSession starts a session whose CLI is a fake that answers every
+// prompt by replaying events, so code built on the SDK can be tested without
+// the kimi executable. The session is a regular *kimi.Session, and the turns it
+// returns deliver the events on Turn.Steps and Turn.Events as the real CLI
+// would. options are applied as with kimi.NewSession, and the session is
+// closed when t finishes.
+//
+// The fake CLI is the test binary itself, so the package's TestMain must call
+// Main. A TurnBegin carrying the prompt is sent first unless events starts
+// with one, and a TurnEnd is sent last unless events contains one. Events
+// emitted by the SDK itself, such as wire.ToolCacheHit, cannot be scripted;
+// use wire.UnknownEvent to script an event of a type the SDK does not know.
+func NewFakeSession(t testing.TB, events []wire.Event, options ...kimi.Option) *kimi.Session {
+	t.Helper()
+	if !mainCalled {
+		t.Fatal("kimitest: NewFakeSession requires TestMain to call kimitest.Main")
+	}
+	script, err := marshalScript(events)
+	if err != nil {
+		t.Fatalf("kimitest: %v", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("kimitest: %v", err)
+	}
+	options = append(slices.Clone(options),
+		kimi.WithExecutable(executable),
+		kimi.WithEnv(fakeCLIEnv, script),
+	)
+	session, err := kimi.NewSession(options...)
+	if err != nil {
+		t.Fatalf("kimitest: start fake session: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+// marshalScript encodes events as a JSON array of wire.EventParams, checking
+// that the SDK can decode each of them as the event it is.
+func marshalScript(events []wire.Event) (string, error) {
+	params := make([]wire.EventParams, len(events))
+	for i, event := range events {
+		if event == nil {
+			return "", fmt.Errorf("event %d is nil", i)
+		}
+		params[i] = wire.EventParams{Type: event.EventType(), Payload: event}
+		data, err := json.Marshal(params[i])
+		if err != nil {
+			return "", fmt.Errorf("event %d: %w", i, err)
+		}
+		var decoded wire.EventParams
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return "", fmt.Errorf("event %d (%s) cannot be sent by the CLI: %w", i, event.EventType(), err)
+		}
+		// Events the SDK emits itself decode as unknown ones; only
+		// wire.UnknownEvent may stand for an event the SDK does not know.
+		if _, unknown := decoded.Payload.(wire.UnknownEvent); unknown {
+			if _, ok := event.(wire.UnknownEvent); !ok {
+				return "", fmt.Errorf("event %d (%s) cannot be sent by the CLI", i, event.EventType())
+			}
+		}
+	}
+	data, err := json.Marshal(params)
+	return string(data), err
+}
+
+type fakePayload struct {
+	Version string          `json:"jsonrpc"`
+	ID      string          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// runFakeCLI speaks the CLI's side of the wire protocol on r and w, and
+// returns the exit status.
+func runFakeCLI(script string, args []string, r io.Reader, w io.Writer) int {
+	if slices.Contains(args, "info") {
+		fmt.Fprintf(w, "{\"kimi_cli_version\": \"0.0.0\", \"wire_protocol_version\": %q}\n", fakeWireProtocolVersion)
+		return 0
+	}
+	if !slices.Contains(args, "--wire") {
+		fmt.Fprintln(os.Stderr, "kimitest: fake CLI started without --wire")
+		return 1
+	}
+	var events []json.RawMessage
+	if err := json.Unmarshal([]byte(script), &events); err != nil {
+		fmt.Fprintln(os.Stderr, "kimitest: invalid fake CLI script:", err)
+		return 1
+	}
+	cli := &fakeCLI{events: events, encoder: json.NewEncoder(w)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var req fakePayload
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+		var result any
+		switch req.Method {
+		case "initialize":
+			result = map[string]any{
+				"protocol_version": fakeWireProtocolVersion,
+				"server":           map[string]any{"name": "kimitest", "version": "0.0.0"},
+				"slash_commands":   []any{},
+			}
+		case "prompt":
+			result = cli.prompt(req.Params)
+		case "cancel":
+			result = struct{}{}
+		default:
+			// Responses to the events sent by the fake.
+			continue
+		}
+		if err := cli.send(fakePayload{ID: req.ID}, result); err != nil {
+			return 1
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return 1
+	}
+	return 0
+}
+
+type fakeCLI struct {
+	events  []json.RawMessage
+	encoder *json.Encoder
+	seq     int
+}
+
+func (c *fakeCLI) send(payload fakePayload, result any) error {
+	payload.Version = "2.0"
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if payload.Method != "" {
+		payload.Params = data
+	} else {
+		payload.Result = data
+	}
+	return c.encoder.Encode(payload)
+}
+
+func (c *fakeCLI) event(params json.RawMessage) {
+	c.seq++
+	c.send(fakePayload{ID: fmt.Sprintf("fake-%d", c.seq), Method: "event"}, params) //nolint:errcheck
+}
+
+// prompt replays the script and returns the result of the prompt.
+func (c *fakeCLI) prompt(params json.RawMessage) wire.PromptResult {
+	var prompt struct {
+		UserInput json.RawMessage `json:"user_input"`
+	}
+	json.Unmarshal(params, &prompt) //nolint:errcheck
+	var types []wire.EventType
+	for _, event := range c.events {
+		var header struct {
+			Type wire.EventType `json:"type"`
+		}
+		json.Unmarshal(event, &header) //nolint:errcheck
+		types = append(types, header.Type)
+	}
+	if len(types) == 0 || types[0] != wire.EventTypeTurnBegin {
+		begin, _ := json.Marshal(map[string]any{
+			"type":    wire.EventTypeTurnBegin,
+			"payload": map[string]json.RawMessage{"user_input": prompt.UserInput},
+		})
+		c.event(begin)
+	}
+	steps := 0
+	for i, event := range c.events {
+		if types[i] == wire.EventTypeStepBegin {
+			steps++
+		}
+		c.event(event)
+	}
+	if !slices.Contains(types, wire.EventTypeTurnEnd) {
+		c.event(json.RawMessage(`{"type":"TurnEnd","payload":{}}`))
+	}
+	return wire.PromptResult{
+		Status: wire.PromptResultStatusFinished,
+		Steps:  wire.Optional[int]{Value: steps, Valid: true},
+	}
+}
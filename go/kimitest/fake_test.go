@@ -0,0 +1,94 @@
+package kimitest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+func TestMain(m *testing.M) {
+	Main(m)
+}
+
+func TestNewFakeSession(t *testing.T) {
+	LeakCheck(t)
+	session := NewFakeSession(t, []wire.Event{
+		wire.StepBegin{N: 1},
+		wire.NewTextContentPart("Hello"),
+		wire.StatusUpdate{TokenUsage: wire.Optional[wire.TokenUsage]{Valid: true, Value: wire.TokenUsage{InputOther: 10, Output: 5}}},
+		wire.StepInterrupted{},
+	})
+
+	// Every prompt replays the script.
+	for range 2 {
+		turn, err := session.Prompt(context.Background(), wire.NewStringContent("Hi"))
+		if err != nil {
+			t.Fatalf("Prompt: %v", err)
+		}
+		var text strings.Builder
+		var types []wire.EventType
+		for event := range turn.Events() {
+			types = append(types, event.EventType())
+			if part, ok := event.(wire.ContentPart); ok {
+				text.WriteString(part.Text.Value)
+			}
+		}
+		if err := turn.Err(); err != nil {
+			t.Fatalf("turn error: %v", err)
+		}
+		want := []wire.EventType{
+			wire.EventTypeTurnBegin,
+			wire.EventTypeStepBegin,
+			wire.EventTypeContentPart,
+			wire.EventTypeStatusUpdate,
+			wire.EventTypeStepInterrupted,
+			wire.EventTypeTurnEnd,
+		}
+		if !slices.Equal(types, want) {
+			t.Errorf("expected events %v, got %v", want, types)
+		}
+		if text.String() != "Hello" {
+			t.Errorf("expected text Hello, got %q", text.String())
+		}
+		if got := turn.Usage().Tokens.Total(); got != 15 {
+			t.Errorf("expected 15 tokens, got %d", got)
+		}
+		if result := turn.Result(); result.Status != wire.PromptResultStatusFinished {
+			t.Errorf("expected status finished, got %s", result.Status)
+		}
+	}
+}
+
+func TestMarshalScript_SDKEvent(t *testing.T) {
+	if _, err := marshalScript([]wire.Event{wire.ToolCacheHit{Name: "search"}}); err == nil {
+		t.Fatal("expected an error for an event the CLI cannot send")
+	}
+}
+
+func TestNewFakeSession_UnknownEvent(t *testing.T) {
+	LeakCheck(t)
+	session := NewFakeSession(t, []wire.Event{
+		wire.StepBegin{N: 1},
+		wire.UnknownEvent{Type: "FutureEvent", Raw: []byte(`{"n":1}`)},
+	})
+
+	turn, err := session.Prompt(context.Background(), wire.NewStringContent("Hi"))
+	if err != nil {
+		t.Fatalf("Prompt: %v", err)
+	}
+	var unknown []wire.UnknownEvent
+	for event := range turn.Events() {
+		if e, ok := event.(wire.UnknownEvent); ok {
+			unknown = append(unknown, e)
+		}
+	}
+	if err := turn.Err(); err != nil {
+		t.Fatalf("turn error: %v", err)
+	}
+	if len(unknown) != 1 || unknown[0].Type != "FutureEvent" || string(unknown[0].Raw) != `{"n":1}` {
+		t.Fatalf("expected the unknown event to be delivered, got %+v", unknown)
+	}
+}
@@ -0,0 +1,85 @@
+// Package kimitest provides utilities for testing code built on the Kimi
+// Agent SDK.
+package kimitest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sdkModule = "github.com/MoonshotAI/kimi-agent-sdk/go"
+
+// LeakTimeout is how long LeakCheck waits for goroutines to exit before it
+// reports them as leaked.
+var LeakTimeout = 5 * time.Second
+
+// LeakCheck snapshots the goroutines running SDK code and registers a cleanup
+// on t that fails the test if SDK goroutines started during the test (readers,
+// watchers, per-turn workers) are still running after it finishes, i.e. after
+// every Session has been closed. Call it at the start of the test:
+//
+//	func TestMyService(t *testing.T) {
+//		kimitest.LeakCheck(t)
+//		...
+//	}
+//
+// Goroutines are given up to LeakTimeout to exit, as shutdown is asynchronous.
+// LeakCheck must not be used in tests that run in parallel with other tests
+// creating sessions.
+func LeakCheck(t testing.TB) {
+	t.Helper()
+	before := sdkGoroutines()
+	t.Cleanup(func() {
+		t.Helper()
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			var leaked []string
+			for id, stack := range sdkGoroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("kimitest: %d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// sdkGoroutines returns the stacks of all goroutines that execute SDK code,
+// keyed by goroutine ID. The goroutine of LeakCheck itself is excluded.
+func sdkGoroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	goroutines := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		header, frames, _ := strings.Cut(string(stack), "\n")
+		id, ok := strings.CutPrefix(header, "goroutine ")
+		if !ok {
+			continue
+		}
+		id, _, _ = strings.Cut(id, " ")
+		if strings.Contains(frames, sdkModule+"/kimitest.") {
+			continue
+		}
+		if strings.Contains(frames, sdkModule) {
+			goroutines[id] = string(stack)
+		}
+	}
+	return goroutines
+}
@@ -0,0 +1,61 @@
+package kimitest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire/jsonrpc2"
+)
+
+type recordingTB struct {
+	testing.TB
+	cleanups []func()
+	failed   bool
+}
+
+func (r *recordingTB) Helper()               {}
+func (r *recordingTB) Cleanup(f func())      { r.cleanups = append(r.cleanups, f) }
+func (r *recordingTB) Errorf(string, ...any) { r.failed = true }
+func (r *recordingTB) runCleanups() {
+	for _, f := range r.cleanups {
+		f()
+	}
+}
+
+func newCodec() (*jsonrpc2.Codec, io.Closer) {
+	a, b := net.Pipe()
+	return jsonrpc2.NewCodec(a), b
+}
+
+func TestLeakCheck_Leaked(t *testing.T) {
+	old := LeakTimeout
+	LeakTimeout = 100 * time.Millisecond
+	defer func() { LeakTimeout = old }()
+
+	tb := &recordingTB{TB: t}
+	LeakCheck(tb)
+	codec, peer := newCodec()
+	defer peer.Close()
+	defer codec.Close()
+	for deadline := time.Now().Add(time.Second); len(sdkGoroutines()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	tb.runCleanups()
+	if !tb.failed {
+		t.Fatal("expected leaked codec goroutines to be reported")
+	}
+}
+
+func TestLeakCheck_Clean(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	LeakCheck(tb)
+	codec, peer := newCodec()
+	peer.Close()
+	codec.Close()
+	tb.runCleanups()
+	if tb.failed {
+		t.Fatal("expected no leak after codec is closed")
+	}
+}
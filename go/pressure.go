@@ -0,0 +1,62 @@
+package kimi
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// contextPressureThreshold is the fraction of the model's context window in
+// use at which WithContextPressureCallback callbacks run.
+const contextPressureThreshold = 0.8
+
+// contextPressure tracks whether the context usage of a session is above
+// contextPressureThreshold across its turns.
+type contextPressure struct {
+	callbacks []func(used, max int)
+	// max is the size of the model's context window, or 0 if it is not known.
+	max   int
+	above atomic.Bool
+}
+
+func newContextPressure(callbacks []func(used, max int), max int) *contextPressure {
+	if len(callbacks) == 0 {
+		return nil
+	}
+	return &contextPressure{callbacks: callbacks, max: max}
+}
+
+// report runs the callbacks if update takes the context usage above the
+// threshold.
+func (p *contextPressure) report(update wire.StatusUpdate) {
+	if !update.ContextUsage.Valid {
+		return
+	}
+	fraction := update.ContextUsage.Value
+	if fraction < contextPressureThreshold {
+		p.above.Store(false)
+		return
+	}
+	if p.above.Swap(true) {
+		return
+	}
+	used, max := p.estimate(update)
+	for _, callback := range p.callbacks {
+		callback(used, max)
+	}
+}
+
+// estimate returns the tokens in use and the size of the context window.
+func (p *contextPressure) estimate(update wire.StatusUpdate) (used, max int) {
+	fraction := update.ContextUsage.Value
+	if p.max > 0 {
+		return int(math.Round(fraction * float64(p.max))), p.max
+	}
+	if !update.TokenUsage.Valid {
+		return 0, 0
+	}
+	tokens := update.TokenUsage.Value
+	used = tokens.InputOther + tokens.InputCacheRead + tokens.InputCacheCreation + tokens.Output
+	return used, int(math.Round(float64(used) / fraction))
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package kimi
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setExitOnParentDeath asks the kernel to SIGKILL the child when its parent
+// goes away. Note that Linux tracks the thread that forked the child rather
+// than the whole process; the Go runtime does not retire threads that are not
+// locked with runtime.LockOSThread, so in practice the signal fires when the
+// Go process exits.
+func setExitOnParentDeath(cmd *exec.Cmd) bool {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	return true
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package kimi
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestSetExitOnParentDeath(t *testing.T) {
+	cmd := exec.Command("kimi")
+	if !setExitOnParentDeath(cmd) {
+		t.Fatal("expected exit on parent death to be supported on linux")
+	}
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Pdeathsig != syscall.SIGKILL {
+		t.Fatalf("expected Pdeathsig=SIGKILL, got %+v", cmd.SysProcAttr)
+	}
+}
+
+func TestSetNiceness(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	afterStart, ok := setNiceness(cmd, 7)
+	if !ok || afterStart == nil {
+		t.Fatal("expected niceness to be supported on linux")
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	if err := afterStart(); err != nil {
+		t.Fatalf("afterStart: %v", err)
+	}
+	// The raw getpriority syscall on Linux returns 20 - nice.
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, cmd.Process.Pid)
+	if err != nil {
+		t.Fatalf("Getpriority: %v", err)
+	}
+	if nice := 20 - prio; nice != 7 {
+		t.Fatalf("expected nice 7, got %d", nice)
+	}
+}
+
+func TestIsolateFromTerminalSignals(t *testing.T) {
+	cmd := exec.Command("kimi")
+	if !isolateFromTerminalSignals(cmd) {
+		t.Fatal("expected signal isolation to be supported on linux")
+	}
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		t.Fatalf("expected Setpgid, got %+v", cmd.SysProcAttr)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package kimi
+
+import (
+	"os/exec"
+)
+
+// setExitOnParentDeath is not supported outside Linux.
+func setExitOnParentDeath(cmd *exec.Cmd) bool {
+	return false
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package kimi
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setNiceness returns a function that sets the nice value of the started
+// process. Unix has no way to pass a priority to fork/exec, so the process
+// briefly runs at the parent's priority until the returned function is called.
+func setNiceness(cmd *exec.Cmd, n int) (afterStart func() error, ok bool) {
+	return func() error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, n)
+	}, true
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// terminate asks the process to exit with SIGTERM.
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// isolateFromTerminalSignals starts the process in its own process group, so
+// that signals the terminal sends to the foreground group (e.g. SIGINT on
+// Ctrl-C) reach the Go process but not the CLI.
+func isolateFromTerminalSignals(cmd *exec.Cmd) bool {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	return true
+}
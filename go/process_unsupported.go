@@ -0,0 +1,30 @@
+//go:build !unix && !windows
+
+package kimi
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setNiceness is not supported on this platform.
+func setNiceness(cmd *exec.Cmd, n int) (afterStart func() error, ok bool) {
+	return nil, false
+}
+
+// processAlive cannot tell on this platform, so it reports every process as
+// alive.
+func processAlive(pid int) bool {
+	return true
+}
+
+// terminate is not supported on this platform.
+func terminate(p *os.Process) error {
+	return errors.ErrUnsupported
+}
+
+// isolateFromTerminalSignals is not supported on this platform.
+func isolateFromTerminalSignals(cmd *exec.Cmd) bool {
+	return false
+}
@@ -0,0 +1,72 @@
+//go:build windows
+
+package kimi
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	idlePriorityClass        = 0x00000040
+	belowNormalPriorityClass = 0x00004000
+	normalPriorityClass      = 0x00000020
+	aboveNormalPriorityClass = 0x00008000
+	highPriorityClass        = 0x00000080
+
+	createNewProcessGroup = 0x00000200
+)
+
+// setNiceness maps the nice value n to a Windows priority class, which is
+// applied when the process is created.
+func setNiceness(cmd *exec.Cmd, n int) (afterStart func() error, ok bool) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= priorityClass(n)
+	return nil, true
+}
+
+func priorityClass(n int) uint32 {
+	switch {
+	case n >= 10:
+		return idlePriorityClass
+	case n > 0:
+		return belowNormalPriorityClass
+	case n == 0:
+		return normalPriorityClass
+	case n > -10:
+		return aboveNormalPriorityClass
+	default:
+		return highPriorityClass
+	}
+}
+
+// processAlive reports whether a process with the given PID exists, which
+// FindProcess finds out on Windows by opening it.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// terminate is not supported on Windows, which has no SIGTERM; callers fall
+// back to closing the process's stdin.
+func terminate(p *os.Process) error {
+	return errors.ErrUnsupported
+}
+
+// isolateFromTerminalSignals creates the process in a new process group,
+// which makes it ignore the CTRL+C events the console sends.
+func isolateFromTerminalSignals(cmd *exec.Cmd) bool {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createNewProcessGroup
+	return true
+}
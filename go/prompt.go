@@ -3,6 +3,7 @@ package kimi
 import (
 	"context"
 	"errors"
+	"sync"
 
 	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
 )
@@ -12,14 +13,28 @@ import (
 type SingleTurn struct {
 	*Turn
 	session *Session
+
+	stop func() bool
+	once sync.Once
+	err  error
 }
 
 // Cancel cancels the turn and closes the session.
 // It is equivalent to Close.
 func (st *SingleTurn) Cancel() error {
-	err1 := st.Turn.Cancel()
-	err2 := st.session.Close()
-	return errors.Join(err1, err2)
+	st.stop()
+	return st.close()
+}
+
+func (st *SingleTurn) close() error {
+	st.once.Do(func() {
+		// Closing the session cancels the turn as well, and does not wait
+		// forever for a CLI that ignores the cancellation.
+		err2 := st.session.Close()
+		err1 := st.Turn.Cancel()
+		st.err = errors.Join(err1, err2)
+	})
+	return st.err
 }
 
 // Close cancels the turn and closes the session.
@@ -29,8 +44,11 @@ func (st *SingleTurn) Close() error {
 
 // Prompt is a convenient function for single-turn prompts.
 // Use SingleTurn.Close() (or Cancel()) to release resources when done.
+// The session lives as long as ctx: once ctx is done, the turn is cancelled
+// and the CLI is shut down as with Session.Close, and Turn.Err returns the
+// context's error.
 func Prompt(ctx context.Context, content wire.Content, options ...Option) (*SingleTurn, error) {
-	session, err := NewSession(options...)
+	session, err := NewSessionContext(ctx, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -39,5 +57,7 @@ func Prompt(ctx context.Context, content wire.Content, options ...Option) (*Sing
 		session.Close() //nolint:errcheck
 		return nil, err
 	}
-	return &SingleTurn{Turn: turn, session: session}, nil
+	st := &SingleTurn{Turn: turn, session: session}
+	st.stop = context.AfterFunc(ctx, func() { st.close() }) //nolint:errcheck
+	return st, nil
 }
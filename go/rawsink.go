@@ -0,0 +1,126 @@
+package kimi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// rawEventSinkBuffer is the number of messages queued for a WithRawEventSink
+// writer before further messages are dropped.
+const rawEventSinkBuffer = 1024
+
+// rawEventTee wraps the CLI's standard output and passes a copy of the bytes
+// read to a json.Decoder, which splits them into messages the way the codec
+// does, whether or not they end with a newline. Messages carrying an event or
+// a request are queued for a single goroutine that writes them to sink, so
+// the sink never holds up the codec.
+type rawEventTee struct {
+	io.ReadCloser
+
+	logger *slog.Logger
+	pw     *io.PipeWriter
+	lines  chan []byte
+	once   sync.Once
+}
+
+func newRawEventTee(r io.ReadCloser, sink io.Writer, logger *slog.Logger) *rawEventTee {
+	pr, pw := io.Pipe()
+	t := &rawEventTee{
+		ReadCloser: r,
+		logger:     logger,
+		pw:         pw,
+		lines:      make(chan []byte, rawEventSinkBuffer),
+	}
+	go t.split(pr)
+	go t.drain(sink)
+	return t
+}
+
+func (t *rawEventTee) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		// Fails only once the tee is finished.
+		t.pw.Write(p[:n]) //nolint:errcheck
+	}
+	if err != nil {
+		t.finish()
+	}
+	return n, err
+}
+
+func (t *rawEventTee) Close() error {
+	err := t.ReadCloser.Close()
+	t.finish()
+	return err
+}
+
+func (t *rawEventTee) finish() {
+	t.once.Do(func() { t.pw.Close() })
+}
+
+// split decodes the messages in r and queues those for the sink, each
+// followed by a newline. A message that fails to decode ends the splitting,
+// as it ends the codec; the rest of r is discarded so reads go on.
+func (t *rawEventTee) split(r *io.PipeReader) {
+	defer close(t.lines)
+	dec := json.NewDecoder(r)
+	dropped, total := 0, 0
+	for {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.logger.Warn("kimi: raw event sink stopped on undecodable output", "error", err)
+				io.Copy(io.Discard, r) //nolint:errcheck
+			}
+			break
+		}
+		if !isRawEventMessage(msg) {
+			continue
+		}
+		select {
+		case t.lines <- append(msg, '\n'):
+			dropped = 0
+		default:
+			if dropped == 0 {
+				t.logger.Warn("kimi: raw event sink is falling behind, dropping events", "buffered", rawEventSinkBuffer)
+			}
+			dropped++
+			total++
+		}
+	}
+	if total > 0 {
+		t.logger.Warn("kimi: raw event sink dropped events", "dropped", total)
+	}
+}
+
+// drain writes the queued messages to sink in the order they were read. A
+// write error is logged once per run of failures, and the next message is
+// still written.
+func (t *rawEventTee) drain(sink io.Writer) {
+	failing := false
+	for line := range t.lines {
+		if _, err := sink.Write(line); err != nil {
+			if !failing {
+				t.logger.Warn("kimi: failed to write to raw event sink", "error", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+	}
+}
+
+// isRawEventMessage reports whether msg is a JSON-RPC message of the CLI's
+// event or request method, i.e. one delivered on Turn.Events.
+func isRawEventMessage(msg json.RawMessage) bool {
+	var header struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(msg, &header) != nil {
+		return false
+	}
+	return header.Method == "event" || header.Method == "request"
+}
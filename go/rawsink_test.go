@@ -0,0 +1,200 @@
+package kimi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	lines   []string
+	block   chan struct{}
+	failing bool
+}
+
+func (s *recordingSink) Write(p []byte) (int, error) {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return 0, errors.New("sink failed")
+	}
+	s.lines = append(s.lines, string(p))
+	return len(p), nil
+}
+
+func (s *recordingSink) Lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func rawEventLines(n int) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, `{"jsonrpc":"2.0","id":"%d","method":"event","params":{"type":"StepBegin","payload":{"n":%d}}}`+"\n", i, i)
+		fmt.Fprintf(&b, `{"jsonrpc":"2.0","id":"r%d","result":{}}`+"\n", i)
+	}
+	return b.String()
+}
+
+// waitLines waits for the drain goroutine of a finished tee to write n lines.
+func waitLines(t *testing.T, sink *recordingSink, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lines := sink.Lines()
+		if len(lines) >= n || time.Now().After(deadline) {
+			return lines
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRawEventTee_Order(t *testing.T) {
+	input := rawEventLines(100)
+	sink := &recordingSink{}
+	tee := newRawEventTee(io.NopCloser(iotest.OneByteReader(strings.NewReader(input))), sink, slog.New(slog.DiscardHandler))
+	got, err := io.ReadAll(tee)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != input {
+		t.Fatal("expected the tee to pass the input through unchanged")
+	}
+	lines := waitLines(t, sink, 100)
+	if len(lines) != 100 {
+		t.Fatalf("expected 100 event lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		want := fmt.Sprintf(`"id":"%d","method":"event"`, i)
+		if !strings.Contains(line, want) || !strings.HasSuffix(line, "\n") {
+			t.Fatalf("line %d: expected %s, got %q", i, want, line)
+		}
+	}
+}
+
+func TestRawEventTee_Framing(t *testing.T) {
+	event := func(i int) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":"%d","method":"event","params":{"type":"StepBegin","payload":{"n":%d}}}`, i, i)
+	}
+	// Two messages on one line, one indented over several lines, and a last
+	// one without a trailing newline, read in chunks that split them.
+	input := event(0) + event(1) + "\n" + `{
+  "jsonrpc": "2.0", "id": "r0", "result": {}
+}` + "\n" + event(2)
+	sink := &recordingSink{}
+	tee := newRawEventTee(io.NopCloser(iotest.HalfReader(strings.NewReader(input))), sink, slog.New(slog.DiscardHandler))
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	lines := waitLines(t, sink, 3)
+	want := []string{event(0) + "\n", event(1) + "\n", event(2) + "\n"}
+	if !slices.Equal(lines, want) {
+		t.Fatalf("expected %q, got %q", want, lines)
+	}
+}
+
+func TestRawEventTee_Undecodable(t *testing.T) {
+	var logs logBuffer
+	input := rawEventLines(1) + "not json\n" + rawEventLines(1)
+	sink := &recordingSink{}
+	tee := newRawEventTee(io.NopCloser(strings.NewReader(input)), sink, slog.New(slog.NewTextHandler(&logs, nil)))
+	got, err := io.ReadAll(tee)
+	if err != nil || string(got) != input {
+		t.Fatalf("expected the input to pass through, got %q, %v", got, err)
+	}
+	if lines := waitLines(t, sink, 1); len(lines) != 1 {
+		t.Errorf("expected the message before the bad output only, got %q", lines)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "undecodable output") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), "undecodable output") {
+		t.Errorf("expected a warning, got %q", logs.String())
+	}
+}
+
+func TestRawEventTee_SlowSink(t *testing.T) {
+	var logs logBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	sink := &recordingSink{block: make(chan struct{})}
+	n := rawEventSinkBuffer + 10
+	tee := newRawEventTee(io.NopCloser(strings.NewReader(rawEventLines(n))), sink, logger)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, tee)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Copy: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a blocked sink held up reading")
+	}
+	// Messages are queued by a goroutine of their own; wait for it to fall
+	// behind before unblocking the sink.
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "dropped events") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), "dropping events") {
+		t.Errorf("expected a warning about dropped events, got %q", logs.String())
+	}
+	close(sink.block)
+	lines := waitLines(t, sink, rawEventSinkBuffer)
+	if len(lines) < rawEventSinkBuffer || len(lines) >= n {
+		t.Errorf("expected between %d and %d lines, got %d", rawEventSinkBuffer, n-1, len(lines))
+	}
+}
+
+func TestRawEventTee_FailingSink(t *testing.T) {
+	var logs logBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	sink := &recordingSink{failing: true}
+	tee := newRawEventTee(io.NopCloser(strings.NewReader(rawEventLines(10))), sink, logger)
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	tee.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "sink failed") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := strings.Count(logs.String(), "failed to write to raw event sink"); got != 1 {
+		t.Errorf("expected one warning for a run of failures, got %d:\n%s", got, logs.String())
+	}
+}
+
+// logBuffer is a bytes.Buffer that is safe for concurrent use.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
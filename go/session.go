@@ -1,15 +1,23 @@
 package kimi
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"net/rpc"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -27,40 +35,246 @@ var (
 	tpname = reflect.TypeOf((*transport.Transport)(nil)).Elem().Name()
 )
 
+var (
+	ErrContentRejected    = errors.New("content rejected")
+	ErrWorkDirNotWritable = errors.New("work directory is not writable")
+	ErrInvalidWorkDir     = errors.New("invalid work directory")
+	ErrInvalidSkillsDir   = errors.New("invalid skills directory")
+	ErrUnknownModelAlias  = errors.New("unknown model alias")
+	// ErrSessionDead is returned by Session.Healthy when the CLI has exited
+	// or stopped answering.
+	ErrSessionDead = errors.New("session is dead")
+)
+
+const (
+	defaultSlowConsumerThreshold = 5 * time.Second
+	interruptEscalationWindow    = 2 * time.Second
+	defaultShutdownTimeout       = 5 * time.Second
+)
+
 func NewSession(options ...Option) (*Session, error) {
+	return NewSessionContext(context.Background(), options...)
+}
+
+// NewSessionContext is like NewSession, but gives up on starting the session
+// once ctx is done. In that case the CLI subprocess is killed and every
+// goroutine started for it has exited before the context's error is
+// returned. The context only bounds startup; it does not affect the session
+// once NewSessionContext has returned.
+func NewSessionContext(ctx context.Context, options ...Option) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	environ := os.Environ()
 	opt := &option{
-		exec: "kimi",
-		args: []string{"--wire"},
-		envs: os.Environ(),
+		exec:      "kimi",
+		args:      []string{"--wire"},
+		envs:      slices.Clone(environ),
+		inherited: len(environ),
+
+		shutdownTimeout: defaultShutdownTimeout,
+		startupAttempts: 1,
 	}
 	for _, f := range options {
 		if f != nil {
 			f(opt)
 		}
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.CommandContext(ctx, opt.exec, opt.args...)
-	cmd.Env = append(cmd.Env, opt.envs...)
-	stdin, err := cmd.StdinPipe()
+	if len(opt.configSources) > 1 {
+		return nil, fmt.Errorf("%w: %s", ErrConflictingConfigOptions, strings.Join(opt.configSources, " and "))
+	}
+	if opt.configEnv != "" {
+		config, err := configFromEnv(opt.configEnv)
+		if err != nil {
+			return nil, err
+		}
+		WithConfig(config)(opt)
+	}
+	if opt.config != nil {
+		if err := opt.config.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	logger := opt.logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	if opt.proxy != "" {
+		if err := checkProxyURL(opt.proxy); err != nil {
+			return nil, err
+		}
+	}
+	if opt.startupAttempts < 1 || opt.startupBackoff < 0 {
+		return nil, fmt.Errorf("invalid startup retry: %d attempts with backoff %v", opt.startupAttempts, opt.startupBackoff)
+	}
+	if opt.niceness != nil && (*opt.niceness < -20 || *opt.niceness > 19) {
+		return nil, fmt.Errorf("niceness %d is out of range [-20, 19]", *opt.niceness)
+	}
+	if opt.envAllowlist != nil {
+		allowed := allowEnv(opt.envs[:opt.inherited], opt.envAllowlist)
+		opt.envs = append(allowed, opt.envs[opt.inherited:]...)
+		opt.inherited = len(allowed)
+	}
+	stored, err := resumeStoredSession(opt)
 	if err != nil {
-		cancel()
 		return nil, err
 	}
-	stdout, err := cmd.StdoutPipe()
+	if opt.modelAliases != nil && opt.model != "" {
+		if err := resolveModelAlias(opt); err != nil {
+			return nil, err
+		}
+	}
+	contextParts, err := readContextFiles(opt.contextFiles)
 	if err != nil {
-		cancel()
 		return nil, err
 	}
-	if err := cmd.Start(); err != nil {
+	var downgrade *wire.CapabilityDowngrade
+	if opt.thinkingBestEffort {
+		var arg string
+		arg, downgrade = bestEffortThinkingArg(opt, logger)
+		opt.args = append(opt.args, arg)
+	}
+	if opt.workDir != "" {
+		if err := prepareWorkDir(opt.workDir, opt.workDirPerm); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range opt.skillsDirs {
+		if err := checkSkillsDir(dir, opt.workDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkWorkDirWritable(cmp.Or(opt.workDir, ".")); err != nil {
+		if opt.requireWritableWorkDir {
+			return nil, fmt.Errorf("%w: %w", ErrWorkDirNotWritable, err)
+		}
+		logger.Warn("kimi: work directory is not writable, tools that modify files will fail", "error", err)
+	}
+	if opt.session == "" {
+		// Choose the ID of a new session here rather than in the CLI, so
+		// Session.ID can report it.
+		opt.session = newSessionID(opt.sessionPrefix)
+		opt.args = append(opt.args, "--session", opt.session)
+	}
+	for _, name := range opt.agent.excludeTools {
+		if slices.Contains(opt.agent.tools, name) {
+			return nil, fmt.Errorf("tool %q is both allowed and disallowed", name)
+		}
+	}
+	if opt.execResolver != nil {
+		executable, err := opt.execResolver()
+		if err != nil {
+			return nil, fmt.Errorf("kimi: resolve executable: %w", err)
+		}
+		if executable == "" {
+			return nil, errors.New("kimi: resolve executable: resolver returned an empty path")
+		}
+		opt.exec = executable
+	}
+	var tempDir string
+	if !opt.agent.empty() {
+		dir, agentFile, err := writeAgentFile(&opt.agent)
+		if err != nil {
+			return nil, fmt.Errorf("write agent file: %w", err)
+		}
+		tempDir = dir
+		opt.args = append(opt.args, "--agent-file", agentFile)
+	}
+	removeTempDir := func() {
+		if tempDir != "" {
+			os.RemoveAll(tempDir)
+		}
+	}
+	startCtx := ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, opt.exec, opt.args...)
+	// A nil Env makes os/exec inherit the whole parent environment, which
+	// must not happen when an allowlist filtered everything out.
+	cmd.Env = append([]string{}, opt.envs...)
+	if opt.exitOnParentDeath && !setExitOnParentDeath(cmd) {
+		logger.Warn("kimi: WithExitOnParentDeath is not supported on this platform")
+	}
+	if len(opt.interruptSignals) > 0 && !isolateFromTerminalSignals(cmd) {
+		logger.Warn("kimi: cannot isolate the subprocess from terminal signals on this platform")
+	}
+	launchEnv := redactEnv(cmd.Env)
+	// Where the CLI records the session, for History. Without a home
+	// directory there is none to read.
+	share, _ := shareDir(cmd.Env)
+	workDir, err := filepath.Abs(cmp.Or(opt.workDir, "."))
+	if err != nil {
+		workDir = opt.workDir
+	}
+	if opt.envSnapshotFile != "" {
+		if err := writeEnvSnapshot(opt.envSnapshotFile, launchEnv); err != nil {
+			logger.Warn("kimi: failed to write environment snapshot", "error", err)
+		}
+	}
+	var setNicenessAfterStart func() error
+	if opt.niceness != nil {
+		var ok bool
+		if setNicenessAfterStart, ok = setNiceness(cmd, *opt.niceness); !ok {
+			logger.Warn("kimi: WithNiceness is not supported on this platform")
+		}
+	}
+	stderr := &stderrTail{tee: opt.stderr}
+	cmd.Stderr = stderr
+	// Tools the CLI spawns may inherit its stderr; don't let them hold up
+	// Wait once the CLI itself has exited.
+	cmd.WaitDelay = time.Second
+	var (
+		stdin  io.WriteCloser
+		stdout io.ReadCloser
+	)
+	err = retryStart(startCtx, opt.startupAttempts, opt.startupBackoff, func(attempt int) error {
+		if attempt > 1 {
+			logger.Warn("kimi: retrying CLI start", "attempt", attempt)
+			// A Cmd cannot be started twice, even after a failed Start.
+			cmd = cloneCmd(ctx, cmd)
+		}
+		var err error
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			stdin.Close()
+			return err
+		}
+		return cmd.Start()
+	})
+	if err != nil {
 		cancel()
-		return nil, err
+		removeTempDir()
+		return nil, startError(err)
+	}
+	logger.Debug("kimi: CLI started",
+		"path", cmd.Path,
+		"args", redactArgs(cmd.Args[1:]),
+		"env", redactEnv(opt.envs[opt.inherited:]),
+		"pid", cmd.Process.Pid)
+	argv := append([]string{cmd.Path}, cmd.Args[1:]...)
+	argvEnv := slices.Clone(cmd.Env)
+	if !opt.unredactedCommandLine {
+		argv = append(argv[:1], redactArgs(argv[1:])...)
+		argvEnv = launchEnv
+	}
+	if setNicenessAfterStart != nil {
+		if err := setNicenessAfterStart(); err != nil {
+			logger.Warn("kimi: failed to set subprocess niceness", "niceness", *opt.niceness, "error", err)
+		}
 	}
 	watch := func() {
 		cmd.Wait()
+		logger.Debug("kimi: CLI exited", "pid", cmd.Process.Pid,
+			"exit_code", cmd.ProcessState.ExitCode(), "status", cmd.ProcessState.String())
 		stdin.Close()
 		stdout.Close()
+		removeTempDir()
 		cancel()
 	}
+	if opt.rawEventSink != nil {
+		stdout = newRawEventTee(stdout, opt.rawEventSink, logger)
+	}
 	codec := jsonrpc2.NewCodec(&stdio{stdin, stdout},
 		jsonrpc2.ClientMethodRenamer(jsonrpc2.RenamerFunc(func(method string) string {
 			return strings.ToLower(strings.TrimPrefix(method, tpname+"."))
@@ -70,52 +284,248 @@ func NewSession(options ...Option) (*Session, error) {
 		})),
 	)
 	tp := transport.NewTransportClient(rpc.NewClientWithCodec(codec))
+	fail := func(err error) (*Session, error) {
+		codec.Close()
+		cancel()
+		watch()
+		if exitErr := exitError(cmd.ProcessState, stderr, true); exitErr != nil {
+			return nil, exitErr
+		}
+		return nil, err
+	}
+	slowConsumerThreshold := opt.slowConsumerThreshold
+	if slowConsumerThreshold <= 0 && opt.slowConsumerCallback != nil {
+		slowConsumerThreshold = defaultSlowConsumerThreshold
+	}
 	session := &Session{
-		ctx:   ctx,
-		cmd:   cmd,
-		codec: codec,
-		tp:    tp,
+		id:                    opt.session,
+		ctx:                   ctx,
+		cmd:                   cmd,
+		codec:                 codec,
+		tp:                    tp,
+		toolCache:             newToolCache(opt.cachedTools),
+		provider:              opt.config.provider(opt.model),
+		probing:               make(chan struct{}, 1),
+		logger:                logger,
+		slowConsumerThreshold: slowConsumerThreshold,
+		slowConsumerCallback:  opt.slowConsumerCallback,
+		contentValidators:     opt.contentValidators,
+		turnResultHooks:       opt.turnResultHooks,
+		turnTimeoutHooks:      opt.turnTimeoutHooks,
+		compactionCallbacks:   opt.compactionCallbacks,
+		contextPressure:       newContextPressure(opt.contextPressureCallbacks, opt.config.maxContextSize(opt.model)),
+		strictWire:            opt.strictWire,
+		promptHistory:         newPromptHistory(opt.promptHistoryFile),
+		coalesceWindow:        opt.coalesceWindow,
+		firstTokenDeadline:    opt.firstTokenDeadline,
+		turnTimeout:           opt.turnTimeout,
+		launchEnv:             launchEnv,
+		argv:                  argv,
+		argvEnv:               argvEnv,
+		shareDir:              share,
+		sessionPrefix:         opt.sessionPrefix,
+		workDir:               workDir,
+		environ:               environ,
+		drainTimeout:          opt.drainTimeout,
+		shutdownTimeout:       opt.shutdownTimeout,
+		stdin:                 stdin,
+		stderr:                stderr,
+		options:               slices.Clone(options),
+		contextParts:          contextParts,
 	}
+	session.downgrade.Store(downgrade)
 	responder := &Responder{
 		rwlock:                  &session.rwlock,
 		pending:                 &session.pending,
 		wireMessageBridge:       &session.wireMessageBridge,
 		wireRequestResponseChan: &session.wireRequestResponseChan,
+		toolCache:               session.toolCache,
+		toolOutputSink:          opt.toolOutputSink,
+		approvalHandler:         opt.approvalHandler,
+		roundtripCtx:            &session.roundtripCtx,
+		logger:                  logger,
 	}
-	wireProtocolVersion, err := getWireProtocolVersion(opt.exec)
+	info, err := getCLIInfo(startCtx, opt.exec, cmd.Env)
 	if err != nil {
-		cancel()
-		return nil, err
+		return fail(cmp.Or(startCtx.Err(), err))
+	}
+	logger.Debug("kimi: CLI info", "version", info.CLIVersion, "wire_protocol_version", info.WireProtocolVersion)
+	if !opt.skipVersionCheck {
+		if err := info.checkCompatible(); err != nil {
+			return fail(err)
+		}
 	}
+	wireProtocolVersion := info.WireProtocolVersion
 	if wireProtocolVersion >= "1.1" {
 		var toolDefs []wire.ExternalTool
 		for _, tool := range opt.tools {
 			toolDefs = append(toolDefs, tool.def)
 		}
-		initResult, err := tp.Initialize(&wire.InitializeParams{
-			ProtocolVersion: wireProtocolVersion,
-			ExternalTools:   toolDefs,
-		})
-		if err != nil {
+		var (
+			initResult *wire.InitializeResult
+			initErr    error
+			initDone   = make(chan struct{})
+		)
+		go func() {
+			defer close(initDone)
+			initResult, initErr = tp.Initialize(&wire.InitializeParams{
+				ProtocolVersion: wireProtocolVersion,
+				ExternalTools:   toolDefs,
+			})
+		}()
+		select {
+		case <-initDone:
+		case <-startCtx.Done():
+			// Kill the subprocess first, so the codec does not wait for a
+			// response that will never come. Closing it then fails the
+			// pending call, which lets the goroutine above finish.
 			cancel()
-			return nil, err
+			defer func() { <-initDone }()
+			return fail(startCtx.Err())
+		}
+		if initErr != nil {
+			return fail(initErr)
 		}
 		if initResult.ExternalTools.Valid && len(initResult.ExternalTools.Value.Rejected) > 0 {
-			cancel()
-			return nil, fmt.Errorf("%q tool is rejected: %s",
+			return fail(fmt.Errorf("%q tool is rejected: %s",
 				initResult.ExternalTools.Value.Rejected[0].Name,
-				initResult.ExternalTools.Value.Rejected[0].Reason)
+				initResult.ExternalTools.Value.Rejected[0].Reason))
 		}
 		session.SlashCommands = initResult.SlashCommands
 		responder.tools = opt.tools
 	}
 	session.wireProtocolVersion = wireProtocolVersion
+	session.cliVersion = info.CLIVersion
 	go session.serve(transport.NewTransportServer(responder))
 	go watch()
+	if len(opt.interruptSignals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, opt.interruptSignals...)
+		go session.handleInterrupts(signals)
+	}
+	if opt.sessionStore != nil {
+		if err := opt.sessionStore.Save(opt.session, storedSessionMeta(opt, workDir, stored)); err != nil {
+			logger.Warn("kimi: failed to save session", "id", opt.session, "error", err)
+		}
+	}
 	return session, nil
 }
 
+// handleInterrupts cancels the active turns on every signal received, and
+// closes the session if a signal follows the previous one within
+// interruptEscalationWindow.
+func (s *Session) handleInterrupts(signals chan os.Signal) {
+	defer signal.Stop(signals)
+	var last time.Time
+	for {
+		select {
+		case sig := <-signals:
+			if !last.IsZero() && time.Since(last) < interruptEscalationWindow {
+				s.logger.Info("kimi: repeated interrupt, closing session", "signal", sig.String())
+				s.Close() //nolint:errcheck
+				return
+			}
+			last = time.Now()
+			s.logger.Info("kimi: interrupt, cancelling active turns", "signal", sig.String())
+			// Interrupt waits for the turns to wind down, which needs the
+			// consumer to keep reading; don't hold up later signals.
+			go s.Interrupt(context.Background()) //nolint:errcheck
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveModelAlias replaces the model selected by WithModel with the model ID
+// its alias maps to, both in opt.model and in the --model argument.
+func resolveModelAlias(opt *option) error {
+	model, ok := opt.modelAliases[opt.model]
+	if !ok {
+		if opt.config != nil {
+			if _, configured := opt.config.Models[opt.model]; configured {
+				return nil
+			}
+		}
+		if !slices.Contains(slices.Collect(maps.Values(opt.modelAliases)), opt.model) {
+			return fmt.Errorf("%w %q (available: %s)", ErrUnknownModelAlias, opt.model,
+				strings.Join(slices.Sorted(maps.Keys(opt.modelAliases)), ", "))
+		}
+		return nil
+	}
+	// WithModel may have been given more than once; the last --model wins.
+	for i := len(opt.args) - 2; i >= 0; i-- {
+		if opt.args[i] == "--model" && opt.args[i+1] == opt.model {
+			opt.args[i+1] = model
+			break
+		}
+	}
+	opt.model = model
+	return nil
+}
+
+// prepareWorkDir creates dir if perm is not zero, and checks that it is a
+// directory.
+func prepareWorkDir(dir string, perm os.FileMode) error {
+	if perm != 0 {
+		if err := os.MkdirAll(dir, perm); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidWorkDir, err)
+		}
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidWorkDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInvalidWorkDir, dir)
+	}
+	return nil
+}
+
+// checkSkillsDir checks that dir, relative to workDir unless absolute, is a
+// directory.
+func checkSkillsDir(dir, workDir string) error {
+	path := dir
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workDir, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSkillsDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInvalidSkillsDir, dir)
+	}
+	return nil
+}
+
+// checkWorkDirWritable reports why files cannot be created in dir, including
+// its permission bits when it exists, or returns nil if they can.
+func checkWorkDirWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".kimi-write-check-*")
+	if err != nil {
+		if info, statErr := os.Stat(dir); statErr == nil {
+			return fmt.Errorf("%s (mode %s): %w", dir, info.Mode(), err)
+		}
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// bestEffortThinkingArg returns the thinking flag for WithThinkingBestEffort,
+// degrading to non-thinking mode when the selected model is known not to
+// support it, in which case it also returns the downgrade to report.
+func bestEffortThinkingArg(opt *option, logger *slog.Logger) (string, *wire.CapabilityDowngrade) {
+	if supported, known := opt.config.supports(opt.model, ModelCapabilityThinking); known && !supported {
+		model := cmp.Or(opt.model, opt.config.DefaultModel)
+		logger.Warn("kimi: model does not support thinking, falling back to non-thinking mode", "model", model)
+		return "--no-thinking", &wire.CapabilityDowngrade{Capability: string(ModelCapabilityThinking), Model: model}
+	}
+	return "--thinking", nil
+}
+
 type Session struct {
+	id                      string
 	ctx                     context.Context
 	cmd                     *exec.Cmd
 	codec                   *jsonrpc2.Codec
@@ -124,13 +534,180 @@ type Session struct {
 	seq                     uint64
 	cancellers              []Canceller
 	wireProtocolVersion     string
+	cliVersion              string
 	wireMessageBridge       chan wire.Message
 	wireRequestResponseChan chan wire.RequestResponse
+	roundtripCtx            context.Context
+	probing                 chan struct{}
 	tp                      transport.Transport
+	toolCache               *toolCache
+	provider                string
+	logger                  *slog.Logger
+	slowConsumerThreshold   time.Duration
+	slowConsumerCallback    func(lag time.Duration)
+	contentValidators       []func(ctx context.Context, content wire.Content) error
+	turnResultHooks         []func(ctx context.Context, turn *Turn)
+	turnTimeoutHooks        []func(ctx context.Context, turn *Turn)
+	compactionCallbacks     []func(wire.CompactionEnd)
+	contextPressure         *contextPressure
+	strictWire              bool
+	promptHistory           *promptHistory
+	coalesceWindow          time.Duration
+	firstTokenDeadline      time.Duration
+	turnTimeout             time.Duration
+	launchEnv               []string
+	argv                    []string
+	argvEnv                 []string
+	downgrade               atomic.Pointer[wire.CapabilityDowngrade]
+	killed                  atomic.Bool // the SDK stopped the CLI
+	contextParts            []wire.ContentPart
+	turnIDs                 map[string]struct{}
+	environ                 []string
+	shareDir                string
+	sessionPrefix           string
+	workDir                 string
+	drainTimeout            time.Duration
+	shutdownTimeout         time.Duration
+	stdin                   io.Closer
+	stderr                  *stderrTail
+	options                 []Option
 
 	SlashCommands []wire.SlashCommand
 }
 
+// Clone starts a new, empty session with the options s was created with,
+// followed by extra, e.g. to run independent prompts with the configuration of
+// a template session. The process environment is the one s was started with,
+// not the current one. No conversation history is carried over, and neither
+// is the session ID: the clone gets a fresh one, unless extra contains a
+// WithSession of its own.
+func (s *Session) Clone(extra ...Option) (*Session, error) {
+	options := append([]Option{withEnviron(s.environ)}, s.options...)
+	options = append(options, withoutSession())
+	return NewSession(append(options, extra...)...)
+}
+
+// Fork starts a new session that continues the conversation of s so far, e.g.
+// to try two approaches from the same point without re-running the prompts
+// that led there. The fork gets a fresh session ID and the options s was
+// created with, as with Clone, and is independent of s from then on:
+// prompting or closing one does not affect the other.
+//
+// The CLI has no command to fork a session, so Fork copies the session's
+// directory in the CLI's share directory (KIMI_SHARE_DIR, or ~/.kimi) to the
+// new ID and resumes that. This costs a copy of the session's files and the
+// start of a CLI process, but no model calls. Like History, it relies on the
+// CLI's on-disk layout of sessions. Fork returns ErrTurnInProgress while a
+// turn of s is running, because the CLI may be writing the session's files.
+func (s *Session) Fork(ctx context.Context) (*Session, error) {
+	if s.shareDir == "" {
+		return nil, errors.New("kimi: cannot locate the CLI's share directory: set KIMI_SHARE_DIR")
+	}
+	s.rwlock.RLock()
+	busy := s.wireMessageBridge != nil
+	s.rwlock.RUnlock()
+	if busy {
+		return nil, ErrTurnInProgress
+	}
+	id := newSessionID(s.sessionPrefix)
+	dir := sessionDir(s.shareDir, s.workDir, id)
+	err := os.CopyFS(dir, os.DirFS(sessionDir(s.shareDir, s.workDir, s.id)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.RemoveAll(dir) //nolint:errcheck
+		return nil, fmt.Errorf("kimi: fork session %s: %w", s.id, err)
+	}
+	options := append([]Option{withEnviron(s.environ)}, s.options...)
+	options = append(options, withoutSession(), WithSession(id))
+	fork, err := NewSessionContext(ctx, options...)
+	if err != nil {
+		os.RemoveAll(dir) //nolint:errcheck
+		return nil, err
+	}
+	return fork, nil
+}
+
+// withEnviron replaces the inherited process environment with environ.
+func withEnviron(environ []string) Option {
+	return func(opt *option) {
+		opt.envs = append(slices.Clone(environ), opt.envs[opt.inherited:]...)
+		opt.inherited = len(environ)
+	}
+}
+
+// allowEnv returns the entries of env whose key is in allowlist or starts
+// with KIMI_.
+func allowEnv(env []string, allowlist []string) []string {
+	var allowed []string
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, "KIMI_") || slices.Contains(allowlist, key) {
+			allowed = append(allowed, kv)
+		}
+	}
+	return allowed
+}
+
+// withoutSession undoes WithSession.
+func withoutSession() Option {
+	return func(opt *option) {
+		opt.session = ""
+		args := opt.args[:0]
+		for i := 0; i < len(opt.args); i++ {
+			if opt.args[i] == "--session" && i+1 < len(opt.args) {
+				i++
+				continue
+			}
+			args = append(args, opt.args[i])
+		}
+		opt.args = args
+	}
+}
+
+// LaunchEnv returns the environment the CLI subprocess was launched with, as
+// KEY=value entries, with the values of variables whose names contain KEY,
+// TOKEN, SECRET, PASSWORD, PASSWD, CREDENTIAL, AUTH, COOKIE or PRIVATE
+// replaced by "[REDACTED]", as is the userinfo of URLs in any other value,
+// e.g. HTTPS_PROXY=http://[REDACTED]@proxy:8080.
+func (s *Session) LaunchEnv() []string {
+	return slices.Clone(s.launchEnv)
+}
+
+// CommandLine returns the command the CLI subprocess was spawned with, to
+// reproduce a session by hand: argv holds the resolved executable path
+// followed by the arguments, and env the KEY=value entries of its
+// environment. Secrets are redacted, in env as in LaunchEnv and in argv from
+// inline configs and URLs, unless WithUnredactedCommandLine was given.
+func (s *Session) CommandLine() (argv []string, env []string) {
+	return slices.Clone(s.argv), slices.Clone(s.argvEnv)
+}
+
+// History returns the events the CLI has recorded for the session so far, in
+// the order they were streamed, e.g. to re-render the earlier turns of a
+// session resumed with WithSession. Each message is a wire.Event; requests
+// are not included. A new session without any turns has an empty history.
+// The events are read from the session's wire.jsonl in the CLI's share
+// directory (KIMI_SHARE_DIR, or ~/.kimi).
+func (s *Session) History(ctx context.Context) ([]wire.Message, error) {
+	if s.shareDir == "" {
+		return nil, errors.New("kimi: cannot locate the CLI's share directory: set KIMI_SHARE_DIR")
+	}
+	return readWireFile(ctx, filepath.Join(sessionDir(s.shareDir, s.workDir, s.id), "wire.jsonl"))
+}
+
+// CLIVersion returns the version of the kimi CLI the session runs, as reported
+// by `kimi info` when the session started, or "" if the CLI did not report one.
+func (s *Session) CLIVersion() string {
+	return s.cliVersion
+}
+
+// ID returns the CLI session ID the session was started with: the one given
+// by WithSession, or a random UUID, with the WithSessionNamePrefix prefix if
+// any, for a new session. Pass it to WithSession to resume the conversation
+// in a later session.
+func (s *Session) ID() string {
+	return s.id
+}
+
 func (s *Session) serve(responder *transport.TransportServer) {
 	server := rpc.NewServer()
 	server.RegisterName(tpname, responder)
@@ -142,24 +719,134 @@ func (s *Session) serve(responder *transport.TransportServer) {
 }
 
 func (s *Session) waitForDataExchange() {
+	// Once the CLI has exited, pending requests will never complete.
+	sleep := func(pending int64) bool {
+		select {
+		case <-time.After(time.Duration(pending) * time.Second):
+			return true
+		case <-s.ctx.Done():
+			return false
+		}
+	}
 	for {
 		pending := s.codec.PendingRequests()
-		if pending == 0 {
+		if pending == 0 || !sleep(int64(pending)) {
 			break
 		}
-		time.Sleep(time.Duration(pending) * time.Second)
 	}
 	for {
 		pending := s.pending.Load()
-		if pending == 0 {
+		if pending == 0 || !sleep(pending) {
 			break
 		}
-		time.Sleep(time.Duration(pending) * time.Second)
 	}
 }
 
-func (s *Session) Prompt(ctx context.Context, content wire.Content) (*Turn, error) {
-	return roundtrip(ctx, s, &turnConstructor{s.tp, content})
+// Prompt sends content to the CLI and returns the turn it starts. The CLI runs
+// one turn at a time: while the previous turn has not ended, which is the case
+// until its Turn.Steps or Turn.Events has been closed, Prompt fails with
+// ErrTurnInProgress instead of waiting, so it is safe to call from several
+// goroutines, but each caller has to retry or wait itself.
+func (s *Session) Prompt(ctx context.Context, content wire.Content, promptOptions ...PromptOption) (turn *Turn, err error) {
+	popt := &promptOption{}
+	for _, apply := range promptOptions {
+		if apply != nil {
+			apply(popt)
+		}
+	}
+	if popt.metadata != nil {
+		defer func() {
+			if err != nil {
+				err = &MetadataError{Metadata: popt.metadata, err: err}
+			}
+		}()
+	}
+	for _, validate := range s.contentValidators {
+		if err := validate(ctx, content); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrContentRejected, err)
+		}
+	}
+	var options []turnOption
+	if popt.turnID != "" {
+		if !s.claimTurnID(popt.turnID) {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateTurnID, popt.turnID)
+		}
+		options = append(options, withExternalID(popt.turnID))
+	}
+	if popt.metadata != nil {
+		options = append(options, withMetadata(popt.metadata))
+	}
+	for _, hook := range s.turnResultHooks {
+		options = append(options, withTurnHook(func(turn *Turn) { hook(ctx, turn) }))
+	}
+	for _, hook := range s.turnTimeoutHooks {
+		options = append(options, withTimeoutHook(func(turn *Turn) { hook(ctx, turn) }))
+	}
+	if len(s.compactionCallbacks) > 0 {
+		options = append(options, withCompactionCallbacks(s.compactionCallbacks))
+	}
+	if s.contextPressure != nil {
+		options = append(options, withContextPressure(s.contextPressure))
+	}
+	if s.strictWire {
+		options = append(options, withStrictWire())
+	}
+	if s.coalesceWindow > 0 {
+		options = append(options, withCoalesceWindow(s.coalesceWindow))
+	}
+	if s.firstTokenDeadline > 0 {
+		options = append(options, withFirstTokenDeadline(s.firstTokenDeadline))
+	}
+	turnCtx := ctx
+	if popt.metadata != nil {
+		turnCtx = context.WithValue(turnCtx, turnMetadataKey{}, popt.metadata)
+	}
+	if s.turnTimeout > 0 {
+		var cancel context.CancelFunc
+		turnCtx, cancel = context.WithTimeoutCause(turnCtx, s.turnTimeout, ErrTurnTimeout)
+		// Release the timer as soon as the turn is done.
+		options = append(options, withTurnHook(func(*Turn) { cancel() }))
+		defer func() {
+			if err != nil {
+				cancel()
+			}
+		}()
+	}
+	if downgrade := s.downgrade.Swap(nil); downgrade != nil {
+		options = append(options, withNotice(*downgrade))
+	}
+	turn, err = roundtrip(turnCtx, s, &turnConstructor{s.tp, withContextParts(s.contextParts, content), s.provider, options})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = turnContextError(turnCtx)
+		}
+		if popt.turnID != "" {
+			// The turn never started, so the ID may be used for a retry.
+			s.rwlock.Lock()
+			delete(s.turnIDs, popt.turnID)
+			s.rwlock.Unlock()
+		}
+		return nil, err
+	}
+	s.logger.Debug("kimi: turn started", append([]any{"id", turn.ID()}, metadataLogAttrs(turnCtx)...)...)
+	if err := s.promptHistory.append(content); err != nil {
+		s.logger.Warn("kimi: failed to write prompt history", append([]any{"error", err}, metadataLogAttrs(turnCtx)...)...)
+	}
+	return turn, nil
+}
+
+// claimTurnID records id as used, reporting false if it already was.
+func (s *Session) claimTurnID(id string) bool {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	if _, used := s.turnIDs[id]; used {
+		return false
+	}
+	if s.turnIDs == nil {
+		s.turnIDs = make(map[string]struct{})
+	}
+	s.turnIDs[id] = struct{}{}
+	return true
 }
 
 func roundtrip[T any, R any, I interface {
@@ -183,10 +870,26 @@ func roundtrip[T any, R any, I interface {
 		resultPointer           = new(atomic.Pointer[R])
 		wireMessageChan         = make(chan wire.Message)
 	)
+	// Wait for a Healthy probe in flight, and keep new ones from starting
+	// until the turn is registered, so that a probe cannot cancel it.
+	select {
+	case s.probing <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 	s.rwlock.Lock()
+	if s.wireMessageBridge != nil {
+		// The CLI runs one turn at a time, and the bridge routes its events
+		// to a single turn.
+		s.rwlock.Unlock()
+		<-s.probing
+		return nil, ErrTurnInProgress
+	}
 	s.wireMessageBridge = wireMessageBridge
 	s.wireRequestResponseChan = wireRequestResponseChan
+	s.roundtripCtx = ctx
 	s.rwlock.Unlock()
+	<-s.probing
 	var rpcErrorSignal = make(chan struct{})
 	bg.Go(func() {
 		defer close(cargoAvailableChan)
@@ -200,11 +903,7 @@ func roundtrip[T any, R any, I interface {
 				case <-ctx.Done():
 				}
 			})
-			select {
-			case wireMessageChan <- msg:
-			case <-rpcErrorSignal:
-			case <-ctx.Done():
-			}
+			s.deliver(ctx, wireMessageChan, msg, rpcErrorSignal)
 		}
 	})
 	var deliveredSignal = make(chan struct{})
@@ -214,6 +913,7 @@ func roundtrip[T any, R any, I interface {
 			s.rwlock.Lock()
 			s.wireMessageBridge = nil
 			s.wireRequestResponseChan = nil
+			s.roundtripCtx = nil
 			s.rwlock.Unlock()
 			close(wireMessageBridge)
 			close(rpcErrorChan)
@@ -239,6 +939,12 @@ func roundtrip[T any, R any, I interface {
 		resultPointer.Store(rpcresult)
 	})
 	exit := func(err error) error {
+		// The turn stops reading once it is cancelled, so discard whatever is
+		// still in flight to let the forwarder reach the end of the bridge.
+		go func() {
+			for range wireMessageChan {
+			}
+		}()
 		for range wireMessageBridge {
 		}
 		bg.Wait()
@@ -253,8 +959,8 @@ func roundtrip[T any, R any, I interface {
 		s.rwlock.Unlock()
 		select {
 		case <-s.ctx.Done():
-			if state := s.cmd.ProcessState; state.ExitCode() > 0 {
-				return errors.New(state.String())
+			if err := exitError(s.cmd.ProcessState, s.stderr, s.killed.Load()); err != nil {
+				return err
 			}
 		default:
 		}
@@ -288,6 +994,44 @@ func roundtrip[T any, R any, I interface {
 	}
 }
 
+// deliver forwards msg to the turn, reporting a slow consumer each time the
+// message has been pending for longer than the configured threshold.
+func (s *Session) deliver(ctx context.Context, wireMessageChan chan<- wire.Message, msg wire.Message, abort <-chan struct{}) {
+	if s.slowConsumerThreshold <= 0 {
+		select {
+		case wireMessageChan <- msg:
+		case <-abort:
+		case <-ctx.Done():
+		}
+		return
+	}
+	start := time.Now()
+	timer := time.NewTimer(s.slowConsumerThreshold)
+	defer timer.Stop()
+	for {
+		select {
+		case wireMessageChan <- msg:
+			return
+		case <-abort:
+			return
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			lag := time.Since(start)
+			s.logger.Warn("kimi: slow consumer, message not received within threshold",
+				append([]any{
+					"lag", lag,
+					"threshold", s.slowConsumerThreshold,
+					"message", fmt.Sprintf("%T", msg),
+				}, metadataLogAttrs(ctx)...)...)
+			if s.slowConsumerCallback != nil {
+				s.slowConsumerCallback(lag)
+			}
+			timer.Reset(s.slowConsumerThreshold)
+		}
+	}
+}
+
 type Responder struct {
 	transport.Transport
 	rwlock                  *sync.RWMutex
@@ -295,6 +1039,11 @@ type Responder struct {
 	wireMessageBridge       *chan wire.Message
 	wireRequestResponseChan *chan wire.RequestResponse
 	tools                   []Tool
+	toolCache               *toolCache
+	toolOutputSink          func(toolName, callID string) (io.WriteCloser, error)
+	approvalHandler         func(ctx context.Context, request wire.ApprovalRequest) (wire.ApprovalRequestResponse, error)
+	roundtripCtx            *context.Context
+	logger                  *slog.Logger
 }
 
 func (r *Responder) Event(event *wire.EventParams) (*wire.EventResult, error) {
@@ -302,17 +1051,51 @@ func (r *Responder) Event(event *wire.EventParams) (*wire.EventResult, error) {
 	defer r.pending.Add(-1)
 	r.rwlock.RLock()
 	defer r.rwlock.RUnlock()
+	r.logger.Debug("kimi: received event", append([]any{"type", event.Type}, r.metadataLogAttrs()...)...)
 	if *r.wireMessageBridge != nil {
 		*r.wireMessageBridge <- event.Payload
 	}
 	return &wire.EventResult{}, nil
 }
 
+// metadataLogAttrs returns the log attributes for the metadata of the turn in
+// progress. The caller must hold rwlock.
+func (r *Responder) metadataLogAttrs() []any {
+	if r.roundtripCtx == nil {
+		return nil
+	}
+	return metadataLogAttrs(*r.roundtripCtx)
+}
+
+// approve answers req with the approval handler, rejecting it if the handler
+// fails or returns an unknown response.
+func (r *Responder) approve(req wire.ApprovalRequest) wire.ApprovalRequestResponse {
+	ctx := context.Background()
+	if r.roundtripCtx != nil && *r.roundtripCtx != nil {
+		ctx = *r.roundtripCtx
+	}
+	response, err := r.approvalHandler(ctx, req)
+	switch {
+	case err != nil:
+		r.logger.Warn("kimi: approval handler failed, rejecting request",
+			append([]any{"request_id", req.ID, "tool_call_id", req.ToolCallID, "error", err}, metadataLogAttrs(ctx)...)...)
+	case response == wire.ApprovalRequestResponseApprove,
+		response == wire.ApprovalRequestResponseApproveForSession,
+		response == wire.ApprovalRequestResponseReject:
+		return response
+	default:
+		r.logger.Warn("kimi: approval handler returned an invalid response, rejecting request",
+			append([]any{"request_id", req.ID, "tool_call_id", req.ToolCallID, "response", response}, metadataLogAttrs(ctx)...)...)
+	}
+	return wire.ApprovalRequestResponseReject
+}
+
 func (r *Responder) Request(request *wire.RequestParams) (wire.RequestResult, error) {
 	r.pending.Add(1)
 	defer r.pending.Add(-1)
 	r.rwlock.RLock()
 	defer r.rwlock.RUnlock()
+	r.logger.Debug("kimi: received request", append([]any{"type", request.Type}, r.metadataLogAttrs()...)...)
 	if *r.wireMessageBridge == nil || *r.wireRequestResponseChan == nil {
 		return nil, jsonrpc2.Error{
 			Code:    jsonrpc2.ErrorCodeInternalError,
@@ -321,6 +1104,9 @@ func (r *Responder) Request(request *wire.RequestParams) (wire.RequestResult, er
 	}
 	switch req := request.Payload.(type) {
 	case wire.ApprovalRequest:
+		if r.approvalHandler != nil {
+			return &wire.ApprovalResponse{RequestID: req.ID, Response: r.approve(req)}, nil
+		}
 		req.Responder = ResponderFunc(func(rr wire.RequestResponse) error {
 			if _, ok := rr.(wire.ApprovalRequestResponse); !ok {
 				return fmt.Errorf("invalid approval request response type: %T", rr)
@@ -336,7 +1122,21 @@ func (r *Responder) Request(request *wire.RequestParams) (wire.RequestResult, er
 	case wire.ToolCallRequest:
 		for _, tool := range r.tools {
 			if req.Name == tool.def.Name && req.Arguments.Valid {
-				toolResult, err := tool.call(json.RawMessage(req.Arguments.Value))
+				toolResult, cached := r.toolCache.load(req.Name, req.Arguments.Value)
+				var err error
+				if cached {
+					*r.wireMessageBridge <- wire.ToolCacheHit{ToolCallID: req.ID, Name: req.Name}
+				} else if toolResult, err = tool.call(json.RawMessage(req.Arguments.Value)); err == nil {
+					r.toolCache.store(req.Name, req.Arguments.Value, toolResult)
+				}
+				if err == nil && r.toolOutputSink != nil {
+					if summary, ok, sinkErr := sinkToolOutput(r.toolOutputSink, req.Name, req.ID, toolResult); sinkErr != nil {
+						r.logger.Warn("kimi: failed to write tool output to sink, returning it in full",
+							"tool", req.Name, "error", sinkErr)
+					} else if ok {
+						toolResult = summary
+					}
+				}
 				var output wire.Content
 				if err != nil {
 					output = wire.NewStringContent(err.Error())
@@ -366,8 +1166,44 @@ func (r *Responder) Request(request *wire.RequestParams) (wire.RequestResult, er
 	}
 }
 
+// Interrupt cancels the turns in progress, as if Turn.Cancel had been called
+// on each of them, and waits for them to wind down, while keeping the session
+// open for the next Prompt. The turns only finish once their consumers have
+// read the remaining messages. If ctx is done first, Interrupt returns its
+// error without waiting further. Calling Interrupt when no turn is active is a
+// no-op returning nil, and concurrent calls are safe.
+func (s *Session) Interrupt(ctx context.Context) error {
+	s.rwlock.RLock()
+	cancellers := slices.Clone(s.cancellers)
+	s.rwlock.RUnlock()
+	if len(cancellers) == 0 {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		var (
+			wg   sync.WaitGroup
+			errs = make([]error, len(cancellers))
+		)
+		for i, canceller := range cancellers {
+			wg.Go(func() { errs[i] = canceller.Cancel() })
+		}
+		wg.Wait()
+		done <- errors.Join(errs...)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s *Session) Close() error {
 	defer s.codec.Close()
+	if s.drainTimeout > 0 {
+		s.drain(s.drainTimeout)
+	}
 	s.rwlock.Lock()
 	cancels := make([]func() error, len(s.cancellers))
 	for i, canceller := range s.cancellers {
@@ -375,10 +1211,65 @@ func (s *Session) Close() error {
 	}
 	s.cancellers = nil
 	s.rwlock.Unlock()
-	for _, cancel := range cancels {
-		cancel() //nolint:errcheck
+	// Cancelling a turn waits for the CLI to end it. A CLI that ignores the
+	// cancellation only lets go once it is shut down, so stop waiting after
+	// the shutdown timeout.
+	cancelled := make(chan struct{})
+	go func() {
+		defer close(cancelled)
+		for _, cancel := range cancels {
+			cancel() //nolint:errcheck
+		}
+	}()
+	timer := time.NewTimer(s.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-cancelled:
+	case <-timer.C:
+	}
+	s.toolCache.clear()
+	err := s.shutdown()
+	<-cancelled
+	return err
+}
+
+// shutdown asks the CLI to exit and kills it if it is still running after
+// the shutdown timeout. It returns an error if the CLI exited with a non-zero
+// status.
+func (s *Session) shutdown() error {
+	s.killed.Store(true)
+	if s.shutdownTimeout > 0 {
+		if err := terminate(s.cmd.Process); err != nil {
+			// No signal to send: closing stdin tells the CLI the session
+			// is over.
+			s.stdin.Close()
+		}
+		timer := time.NewTimer(s.shutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-s.ctx.Done():
+		case <-timer.C:
+			s.logger.Warn("kimi: CLI did not exit within the shutdown timeout, killing it",
+				"timeout", s.shutdownTimeout)
+		}
+	}
+	s.cmd.Cancel() //nolint:errcheck
+	<-s.ctx.Done()
+	return exitError(s.cmd.ProcessState, s.stderr, true)
+}
+
+// drain waits until no turn is active, or until timeout has elapsed.
+func (s *Session) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.rwlock.RLock()
+		active := len(s.cancellers)
+		s.rwlock.RUnlock()
+		if active == 0 || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
-	return s.cmd.Cancel()
 }
 
 type stdio struct {
@@ -428,12 +1319,15 @@ type Constructor[T any, R any] interface {
 type turnConstructor struct {
 	transport transport.Transport
 	content   wire.Content
+	provider  string
+	options   []turnOption
 }
 
 func (tc *turnConstructor) RPCRequest() (*wire.PromptResult, error) {
-	return tc.transport.Prompt(&wire.PromptParams{
+	result, err := tc.transport.Prompt(&wire.PromptParams{
 		UserInput: tc.content,
 	})
+	return result, providerError(err, tc.provider)
 }
 
 func (tc *turnConstructor) Construct(
@@ -457,23 +1351,41 @@ func (tc *turnConstructor) Construct(
 		wireMessageChan,
 		wireRequestResponseChan,
 		exit,
+		tc.options...,
 	)
 }
 
-func getWireProtocolVersion(executable string) (string, error) {
-	cmd := exec.Command(executable, "info", "--json")
+// newSessionID returns prefix, restricted to characters allowed in session
+// IDs, followed by a random (version 4) UUID.
+func newSessionID(prefix string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, prefix)
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%s%x-%x-%x-%x-%x", sanitized, uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+func getCLIInfo(ctx context.Context, executable string, env []string) (*cliInfo, error) {
+	cmd := exec.CommandContext(ctx, executable, "info", "--json")
+	cmd.Env = env
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if !cmd.ProcessState.Success() {
-		return "", errors.New(string(output))
-	}
-	var info struct {
-		WireProtocolVersion string `json:"wire_protocol_version"`
+		return nil, errors.New(string(output))
 	}
+	var info cliInfo
 	if err := json.Unmarshal(output, &info); err != nil {
-		return "", err
+		return nil, err
 	}
-	return info.WireProtocolVersion, nil
+	return &info, nil
 }
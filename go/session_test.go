@@ -1,10 +1,19 @@
 package kimi
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
 )
@@ -14,7 +23,7 @@ func TestResponder_Event(t *testing.T) {
 	usrc := make(chan wire.RequestResponse, 1)
 
 	var rwlock sync.RWMutex
-	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc}
+	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc, logger: slog.New(slog.DiscardHandler)}
 
 	event := &wire.EventParams{
 		Type:    wire.EventTypeContentPart,
@@ -47,7 +56,7 @@ func TestResponder_Event_NilMsgs(t *testing.T) {
 	var msgs chan wire.Message
 	usrc := make(chan wire.RequestResponse, 1)
 	var rwlock sync.RWMutex
-	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc}
+	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc, logger: slog.New(slog.DiscardHandler)}
 
 	event := &wire.EventParams{
 		Type:    wire.EventTypeContentPart,
@@ -69,7 +78,7 @@ func TestResponder_Request_ApprovalRequest(t *testing.T) {
 	usrc := make(chan wire.RequestResponse, 1)
 
 	var rwlock sync.RWMutex
-	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc}
+	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc, logger: slog.New(slog.DiscardHandler)}
 
 	approvalRequest := wire.ApprovalRequest{
 		ID:          "req-123",
@@ -127,11 +136,71 @@ func TestResponder_Request_ApprovalRequest(t *testing.T) {
 	}
 }
 
+func TestResponder_Request_ApprovalHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		response wire.ApprovalRequestResponse
+		err      error
+		want     wire.ApprovalRequestResponse
+	}{
+		{"approve", wire.ApprovalRequestResponseApprove, nil, wire.ApprovalRequestResponseApprove},
+		{"approve for session", wire.ApprovalRequestResponseApproveForSession, nil, wire.ApprovalRequestResponseApproveForSession},
+		{"reject", wire.ApprovalRequestResponseReject, nil, wire.ApprovalRequestResponseReject},
+		{"error", wire.ApprovalRequestResponseApprove, errors.New("denied by policy"), wire.ApprovalRequestResponseReject},
+		{"invalid", "maybe", nil, wire.ApprovalRequestResponseReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := make(chan wire.Message, 1)
+			usrc := make(chan wire.RequestResponse, 1)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var rwlock sync.RWMutex
+			responder := &Responder{
+				rwlock:                  &rwlock,
+				pending:                 new(atomic.Int64),
+				wireMessageBridge:       &msgs,
+				wireRequestResponseChan: &usrc,
+				roundtripCtx:            &ctx,
+				logger:                  slog.New(slog.DiscardHandler),
+				approvalHandler: func(got context.Context, request wire.ApprovalRequest) (wire.ApprovalRequestResponse, error) {
+					if got != ctx {
+						t.Error("handler did not receive the roundtrip context")
+					}
+					if request.ID != "req-123" {
+						t.Errorf("expected ID 'req-123', got %s", request.ID)
+					}
+					return tt.response, tt.err
+				},
+			}
+
+			result, err := responder.Request(&wire.RequestParams{
+				Type:    wire.RequestTypeApprovalRequest,
+				Payload: wire.ApprovalRequest{ID: "req-123", ToolCallID: "tool-456"},
+			})
+			if err != nil {
+				t.Fatalf("Request: %v", err)
+			}
+			resp, ok := result.(*wire.ApprovalResponse)
+			if !ok {
+				t.Fatalf("expected *wire.ApprovalResponse, got %T", result)
+			}
+			if resp.RequestID != "req-123" || resp.Response != tt.want {
+				t.Errorf("expected response %s for req-123, got %s for %s", tt.want, resp.Response, resp.RequestID)
+			}
+			if len(msgs) != 0 {
+				t.Error("approval request was delivered to the turn")
+			}
+		})
+	}
+}
+
 func TestResponder_Request_NilMsgs(t *testing.T) {
 	var msgs chan wire.Message
 	usrc := make(chan wire.RequestResponse, 1)
 	var rwlock sync.RWMutex
-	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc}
+	responder := &Responder{rwlock: &rwlock, pending: new(atomic.Int64), wireMessageBridge: &msgs, wireRequestResponseChan: &usrc, logger: slog.New(slog.DiscardHandler)}
 
 	approvalRequest := wire.ApprovalRequest{
 		ID:          "req-123",
@@ -200,3 +269,600 @@ func TestStdio_Close(t *testing.T) {
 		t.Error("expected read to fail after close")
 	}
 }
+
+func TestResponder_Request_ToolCache(t *testing.T) {
+	msgs := make(chan wire.Message, 1)
+	usrc := make(chan wire.RequestResponse, 1)
+
+	var calls int
+	tool, err := CreateTool(func(args SimpleArgs) (string, error) {
+		calls++
+		return "echo: " + args.Input, nil
+	}, WithName("echo"))
+	if err != nil {
+		t.Fatalf("CreateTool: %v", err)
+	}
+
+	var rwlock sync.RWMutex
+	responder := &Responder{
+		rwlock:                  &rwlock,
+		pending:                 new(atomic.Int64),
+		wireMessageBridge:       &msgs,
+		wireRequestResponseChan: &usrc,
+		tools:                   []Tool{tool},
+		toolCache:               newToolCache([]string{"echo"}),
+		logger:                  slog.New(slog.DiscardHandler),
+	}
+
+	call := func(id, args string) *wire.ToolResult {
+		t.Helper()
+		result, err := responder.Request(&wire.RequestParams{
+			Type: wire.RequestTypeToolCallRequest,
+			Payload: wire.ToolCallRequest{
+				ID:        id,
+				Name:      "echo",
+				Arguments: wire.Optional[string]{Value: args, Valid: true},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		return result.(*wire.ToolResult)
+	}
+
+	first := call("call-1", `{"input":"hi"}`)
+	select {
+	case msg := <-msgs:
+		t.Fatalf("unexpected message on first call: %T", msg)
+	default:
+	}
+
+	second := call("call-2", `{ "input": "hi" }`)
+	if calls != 1 {
+		t.Fatalf("expected tool to be called once, got %d", calls)
+	}
+	if second.ToolCallID != "call-2" {
+		t.Errorf("expected tool_call_id 'call-2', got %s", second.ToolCallID)
+	}
+	if second.ReturnValue.Output.Text.Value != first.ReturnValue.Output.Text.Value {
+		t.Errorf("expected cached output %q, got %q", first.ReturnValue.Output.Text.Value, second.ReturnValue.Output.Text.Value)
+	}
+	select {
+	case msg := <-msgs:
+		hit, ok := msg.(wire.ToolCacheHit)
+		if !ok {
+			t.Fatalf("expected ToolCacheHit, got %T", msg)
+		}
+		if hit.ToolCallID != "call-2" || hit.Name != "echo" {
+			t.Errorf("unexpected cache hit: %+v", hit)
+		}
+	default:
+		t.Fatal("expected ToolCacheHit message in channel")
+	}
+
+	call("call-3", `{"input":"bye"}`)
+	if calls != 2 {
+		t.Fatalf("expected different arguments to invoke the tool, got %d calls", calls)
+	}
+
+	responder.toolCache.clear()
+	call("call-4", `{"input":"hi"}`)
+	if calls != 3 {
+		t.Fatalf("expected cleared cache to invoke the tool, got %d calls", calls)
+	}
+}
+
+func TestSession_Deliver_SlowConsumer(t *testing.T) {
+	var logs bytes.Buffer
+	var lags []time.Duration
+	var mu sync.Mutex
+	s := &Session{
+		logger:                slog.New(slog.NewTextHandler(&logs, nil)),
+		slowConsumerThreshold: 20 * time.Millisecond,
+		slowConsumerCallback: func(lag time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			lags = append(lags, lag)
+		},
+	}
+
+	msgs := make(chan wire.Message)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.deliver(context.Background(), msgs, wire.NewTextContentPart("hello"), nil)
+	}()
+
+	time.Sleep(70 * time.Millisecond)
+	<-msgs
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lags) < 2 {
+		t.Fatalf("expected at least 2 slow consumer reports, got %d", len(lags))
+	}
+	if lags[0] < 20*time.Millisecond {
+		t.Errorf("expected lag >= threshold, got %v", lags[0])
+	}
+	if lags[1] <= lags[0] {
+		t.Errorf("expected growing lag, got %v then %v", lags[0], lags[1])
+	}
+	if !strings.Contains(logs.String(), "slow consumer") {
+		t.Errorf("expected slow consumer warning to be logged, got %q", logs.String())
+	}
+}
+
+func TestSession_Deliver_FastConsumer(t *testing.T) {
+	var reports atomic.Int64
+	s := &Session{
+		logger:                slog.New(slog.DiscardHandler),
+		slowConsumerThreshold: time.Second,
+		slowConsumerCallback:  func(time.Duration) { reports.Add(1) },
+	}
+
+	msgs := make(chan wire.Message, 1)
+	s.deliver(context.Background(), msgs, wire.NewTextContentPart("hello"), nil)
+
+	if len(msgs) != 1 {
+		t.Fatal("expected message to be delivered")
+	}
+	if n := reports.Load(); n != 0 {
+		t.Fatalf("expected no slow consumer reports, got %d", n)
+	}
+}
+
+func TestSession_Prompt_ContentValidator(t *testing.T) {
+	errBanned := errors.New("banned word")
+	var validated []string
+	s := &Session{
+		contentValidators: []func(context.Context, wire.Content) error{
+			func(_ context.Context, content wire.Content) error {
+				validated = append(validated, "first")
+				return nil
+			},
+			func(_ context.Context, content wire.Content) error {
+				validated = append(validated, "second")
+				if strings.Contains(content.Text.Value, "forbidden") {
+					return errBanned
+				}
+				return nil
+			},
+		},
+	}
+
+	turn, err := s.Prompt(context.Background(), wire.NewStringContent("something forbidden"))
+	if turn != nil {
+		t.Fatal("expected no turn when content is rejected")
+	}
+	if !errors.Is(err, ErrContentRejected) {
+		t.Fatalf("expected ErrContentRejected, got %v", err)
+	}
+	if !errors.Is(err, errBanned) {
+		t.Fatalf("expected validator error to be wrapped, got %v", err)
+	}
+	if !reflect.DeepEqual(validated, []string{"first", "second"}) {
+		t.Fatalf("expected validators to run in order, got %v", validated)
+	}
+}
+
+func TestBestEffortThinkingArg(t *testing.T) {
+	config := &Config{
+		DefaultModel: "plain",
+		Models: map[string]LLMModel{
+			"plain": {Provider: "kimi", Model: "plain"},
+			"thinker": {
+				Provider:     "kimi",
+				Model:        "thinker",
+				Capabilities: map[ModelCapability]bool{ModelCapabilityThinking: true},
+			},
+		},
+	}
+	cases := []struct {
+		name    string
+		opt     *option
+		want    string
+		warning bool
+	}{
+		{"NoConfig", &option{}, "--thinking", false},
+		{"DefaultModelUnsupported", &option{config: config}, "--no-thinking", true},
+		{"SelectedModelSupported", &option{config: config, model: "thinker"}, "--thinking", false},
+		{"SelectedModelUnsupported", &option{config: config, model: "plain"}, "--no-thinking", true},
+		{"UnknownModel", &option{config: config, model: "other"}, "--thinking", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			got, downgrade := bestEffortThinkingArg(tc.opt, slog.New(slog.NewTextHandler(&logs, nil)))
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+			if (downgrade != nil) != tc.warning {
+				t.Fatalf("expected downgrade=%v, got %+v", tc.warning, downgrade)
+			}
+			if warned := logs.Len() > 0; warned != tc.warning {
+				t.Fatalf("expected warning=%v, got logs %q", tc.warning, logs.String())
+			}
+		})
+	}
+}
+
+func TestNewSessionID(t *testing.T) {
+	id := newSessionID("ci build/42:")
+	if !strings.HasPrefix(id, "ci-build-42-") {
+		t.Fatalf("expected sanitized prefix, got %q", id)
+	}
+	uuid := strings.TrimPrefix(id, "ci-build-42-")
+	if len(uuid) != 36 || uuid[14] != '4' {
+		t.Fatalf("expected a version 4 UUID suffix, got %q", uuid)
+	}
+	if other := newSessionID("ci build/42:"); other == id {
+		t.Fatalf("expected unique session IDs, got %q twice", id)
+	}
+}
+
+func TestCheckWorkDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkWorkDirWritable(dir); err != nil {
+		t.Fatalf("expected writable temp dir, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("expected probe file to be removed, found %d entries", len(entries))
+	}
+
+	if err := checkWorkDirWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error for missing dir")
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("permission bits are not enforced for root")
+	}
+	readonly := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readonly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	err := checkWorkDirWritable(readonly)
+	if err == nil || !strings.Contains(err.Error(), "dr-xr-xr-x") {
+		t.Fatalf("expected error with permission bits, got %v", err)
+	}
+}
+
+func TestNewSession_RequireWritableWorkDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission bits are not enforced for root")
+	}
+	readonly := filepath.Join(t.TempDir(), "readonly")
+	if err := os.Mkdir(readonly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewSession(
+		WithExecutable("kimi-does-not-exist"),
+		WithWorkDir(readonly),
+		WithRequireWritableWorkDir(),
+	)
+	if !errors.Is(err, ErrWorkDirNotWritable) {
+		t.Fatalf("expected ErrWorkDirNotWritable, got %v", err)
+	}
+}
+
+func TestNewSession_InvalidWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, workDir := range []string{filepath.Join(dir, "missing"), file} {
+		_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithWorkDir(workDir))
+		if !errors.Is(err, ErrInvalidWorkDir) {
+			t.Errorf("WithWorkDir(%q): expected ErrInvalidWorkDir, got %v", workDir, err)
+		}
+	}
+}
+
+func TestNewSession_ExecutableResolverError(t *testing.T) {
+	errNoInstall := errors.New("no managed install")
+	_, err := NewSession(WithExecutableResolver(func() (string, error) { return "", errNoInstall }))
+	if !errors.Is(err, errNoInstall) {
+		t.Fatalf("expected the resolver's error to be wrapped, got %v", err)
+	}
+	_, err = NewSession(WithExecutableResolver(func() (string, error) { return "", nil }))
+	if err == nil || !strings.Contains(err.Error(), "empty path") {
+		t.Fatalf("expected an error for an empty path, got %v", err)
+	}
+}
+
+func TestNewSession_ExecutableResolverNotCalledForInvalidOptions(t *testing.T) {
+	called := false
+	_, err := NewSession(
+		WithExecutableResolver(func() (string, error) {
+			called = true
+			return "kimi", nil
+		}),
+		WithSkillsDir(filepath.Join(t.TempDir(), "missing")),
+	)
+	if !errors.Is(err, ErrInvalidSkillsDir) {
+		t.Fatalf("expected ErrInvalidSkillsDir, got %v", err)
+	}
+	if called {
+		t.Error("expected the resolver not to be called for invalid options")
+	}
+}
+
+func TestNewSession_InvalidSkillsDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, skillsDir := range []string{filepath.Join(dir, "missing"), file} {
+		_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithSkillsDirs(dir, skillsDir))
+		if !errors.Is(err, ErrInvalidSkillsDir) {
+			t.Errorf("WithSkillsDirs(%q): expected ErrInvalidSkillsDir, got %v", skillsDir, err)
+		} else if !strings.Contains(err.Error(), skillsDir) {
+			t.Errorf("expected the error to name %s, got %v", skillsDir, err)
+		}
+	}
+}
+
+func TestCheckSkillsDir_Relative(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(workDir, "skills"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSkillsDir("skills", workDir); err != nil {
+		t.Errorf("expected skills to be found in the work directory, got %v", err)
+	}
+}
+
+func TestPrepareWorkDir_Create(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := prepareWorkDir(dir, 0o750); err != nil {
+		t.Fatalf("prepareWorkDir: %v", err)
+	}
+	// An existing directory is fine.
+	if err := prepareWorkDir(dir, 0o750); err != nil {
+		t.Fatalf("prepareWorkDir on an existing directory: %v", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("expected %s to be created, got %v", dir, err)
+	}
+}
+
+func TestNewSession_NicenessOutOfRange(t *testing.T) {
+	for _, n := range []int{-21, 20} {
+		_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithNiceness(n))
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("WithNiceness(%d): expected out of range error, got %v", n, err)
+		}
+	}
+}
+
+func TestNewSession_ConfigFromEnv(t *testing.T) {
+	_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithConfigFromEnv("KIMI_TEST_CONFIG_UNSET"))
+	if err == nil || !strings.Contains(err.Error(), "KIMI_TEST_CONFIG_UNSET") {
+		t.Fatalf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestNewSession_ConflictingConfigOptions(t *testing.T) {
+	config := &Config{}
+	tests := []struct {
+		name    string
+		options []Option
+		want    string
+	}{
+		{"config and file", []Option{WithConfig(config), WithConfigFile("config.toml")}, "WithConfig and WithConfigFile"},
+		{"env and config", []Option{WithConfigFromEnv("KIMI_CONFIG_JSON"), WithConfig(config)}, "WithConfigFromEnv and WithConfig"},
+		{"file and env", []Option{WithConfigFile("config.toml"), WithConfigFromEnv("KIMI_CONFIG_JSON")}, "WithConfigFile and WithConfigFromEnv"},
+		{"config twice", []Option{WithConfig(config), WithConfig(config)}, "WithConfig and WithConfig"},
+		{"file twice", []Option{WithConfigFile("a.toml"), WithConfigFile("b.toml")}, "WithConfigFile and WithConfigFile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSession(append(tt.options, WithExecutable("kimi-does-not-exist"))...)
+			if !errors.Is(err, ErrConflictingConfigOptions) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected ErrConflictingConfigOptions naming %s, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestNewSession_CombinedMCPConfigs(t *testing.T) {
+	_, err := NewSession(
+		WithExecutable("kimi-does-not-exist"),
+		WithMCPConfig(&MCPConfig{}),
+		WithMCPConfigFile("mcp.json"),
+		WithMCPConfigFile("more-mcp.json"),
+		WithConfigFile("config.toml"),
+	)
+	if !errors.Is(err, ErrExecutableNotFound) {
+		t.Fatalf("expected ErrExecutableNotFound, got %v", err)
+	}
+}
+
+func TestNewSession_InvalidConfig(t *testing.T) {
+	// The config is checked before the executable is looked up.
+	_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithConfig(&Config{
+		Providers: map[string]LLMProvider{"kimi": {Type: ProviderTypeKimi}},
+	}))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestNewSession_InvalidProxy(t *testing.T) {
+	_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithProxy("user:hunter2@proxy.corp:8080"))
+	if err == nil || !strings.Contains(err.Error(), "invalid proxy URL") {
+		t.Fatalf("expected an invalid proxy URL error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("expected credentials to be redacted, got %v", err)
+	}
+}
+
+func TestNewSession_ConflictingTools(t *testing.T) {
+	_, err := NewSession(
+		WithExecutable("kimi-does-not-exist"),
+		WithAllowedTools("kimi_cli.tools.file:ReadFile", "kimi_cli.tools.shell:Shell"),
+		WithDisallowedTools("kimi_cli.tools.shell:Shell"),
+	)
+	if err == nil || !strings.Contains(err.Error(), `tool "kimi_cli.tools.shell:Shell" is both allowed and disallowed`) {
+		t.Fatalf("expected a conflicting tools error, got %v", err)
+	}
+}
+
+func TestNewSession_EnvSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	// The snapshot is written before the subprocess is started, so it is
+	// available even when startup fails.
+	_, err := NewSession(
+		WithExecutable("kimi-does-not-exist"),
+		WithAPIKey("sk-secret"),
+		WithBaseURL("https://api.example.com"),
+		WithEnvSnapshotFile(path),
+	)
+	if err == nil {
+		t.Fatal("expected NewSession to fail without an executable")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	snapshot := string(data)
+	if strings.Contains(snapshot, "sk-secret") {
+		t.Fatal("expected API key to be redacted from the snapshot")
+	}
+	for _, want := range []string{"KIMI_API_KEY=[REDACTED]\n", "KIMI_BASE_URL=https://api.example.com\n"} {
+		if !strings.Contains(snapshot, want) {
+			t.Errorf("expected snapshot to contain %q", want)
+		}
+	}
+}
+
+func TestResponder_Request_ToolOutputSink(t *testing.T) {
+	msgs := make(chan wire.Message, 1)
+	usrc := make(chan wire.RequestResponse, 1)
+
+	long := strings.Repeat("x", 2*toolOutputPreviewSize)
+	tool, err := CreateTool(func(args SimpleArgs) (string, error) {
+		return long, nil
+	}, WithName("dump"))
+	if err != nil {
+		t.Fatalf("CreateTool: %v", err)
+	}
+
+	var sunk bytes.Buffer
+	var rwlock sync.RWMutex
+	responder := &Responder{
+		rwlock:                  &rwlock,
+		pending:                 new(atomic.Int64),
+		wireMessageBridge:       &msgs,
+		wireRequestResponseChan: &usrc,
+		tools:                   []Tool{tool},
+		toolOutputSink: func(toolName, callID string) (io.WriteCloser, error) {
+			return nopCloser{&sunk}, nil
+		},
+		logger: slog.New(slog.DiscardHandler),
+	}
+
+	result, err := responder.Request(&wire.RequestParams{
+		Type: wire.RequestTypeToolCallRequest,
+		Payload: wire.ToolCallRequest{
+			ID:        "call-1",
+			Name:      "dump",
+			Arguments: wire.Optional[string]{Value: `{"input":"x"}`, Valid: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	output := result.(*wire.ToolResult).ReturnValue.Output.Text.Value
+	if sunk.String() != long {
+		t.Errorf("expected full output in sink, got %d bytes", sunk.Len())
+	}
+	if !strings.Contains(output, "[output truncated: showing 1024 of 2048 bytes") {
+		t.Errorf("expected truncated summary, got %q", output)
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestResolveModelAlias(t *testing.T) {
+	aliases := map[string]string{"fast": "kimi-k2-turbo", "smart": "kimi-k2-thinking"}
+
+	opt := &option{}
+	WithModelAliases(aliases)(opt)
+	WithModel("ignored")(opt)
+	WithModel("fast")(opt)
+	if err := resolveModelAlias(opt); err != nil {
+		t.Fatalf("resolveModelAlias: %v", err)
+	}
+	if opt.model != "kimi-k2-turbo" {
+		t.Errorf("expected model kimi-k2-turbo, got %s", opt.model)
+	}
+	if want := []string{"--model", "ignored", "--model", "kimi-k2-turbo"}; !reflect.DeepEqual(opt.args, want) {
+		t.Errorf("expected args %v, got %v", want, opt.args)
+	}
+
+	// Real model IDs that are alias targets or configured models pass through.
+	for _, model := range []string{"kimi-k2-thinking", "configured"} {
+		opt := &option{}
+		WithConfig(&Config{Models: map[string]LLMModel{"configured": {}}})(opt)
+		WithModelAliases(aliases)(opt)
+		WithModel(model)(opt)
+		if err := resolveModelAlias(opt); err != nil || opt.model != model {
+			t.Errorf("expected %s to pass through, got model %s, err %v", model, opt.model, err)
+		}
+	}
+
+	opt = &option{}
+	WithModelAliases(aliases)(opt)
+	WithModel("fsat")(opt)
+	err := resolveModelAlias(opt)
+	if !errors.Is(err, ErrUnknownModelAlias) {
+		t.Fatalf("expected ErrUnknownModelAlias, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "available: fast, smart") {
+		t.Errorf("expected available aliases in error, got %v", err)
+	}
+}
+
+func TestWithoutSession(t *testing.T) {
+	opt := &option{args: []string{"--wire"}}
+	WithModel("kimi")(opt)
+	WithSession("original")(opt)
+	WithAutoApprove()(opt)
+	withoutSession()(opt)
+	WithSession("override")(opt)
+
+	if want := []string{"--wire", "--model", "kimi", "--auto-approve", "--session", "override"}; !reflect.DeepEqual(opt.args, want) {
+		t.Fatalf("expected args %v, got %v", want, opt.args)
+	}
+	if opt.session != "override" {
+		t.Fatalf("expected session override, got %s", opt.session)
+	}
+}
+
+func TestAllowEnv(t *testing.T) {
+	env := []string{"PATH=/usr/bin", "HOME=/root", "AWS_SECRET=xyz", "KIMI_API_KEY=sk", "PATHEXT=.exe"}
+	got := allowEnv(env, []string{"PATH"})
+	if want := []string{"PATH=/usr/bin", "KIMI_API_KEY=sk"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("allowEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestWithEnviron_KeepsOptionEnvs(t *testing.T) {
+	opt := &option{envs: []string{"A=1", "B=2"}, inherited: 2}
+	WithAPIKey("sk")(opt)
+	withEnviron([]string{"C=3"})(opt)
+
+	if want := []string{"C=3", "KIMI_API_KEY=sk"}; !reflect.DeepEqual(opt.envs, want) {
+		t.Fatalf("expected envs %v, got %v", want, opt.envs)
+	}
+	if opt.inherited != 1 {
+		t.Fatalf("expected 1 inherited entry, got %d", opt.inherited)
+	}
+}
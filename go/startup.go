@@ -0,0 +1,48 @@
+package kimi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// retryStart calls start, passing the attempt number from 1, until it
+// succeeds, fails with an error that is not transient, or has been called
+// attempts times. It waits backoff before the first retry and doubles the
+// wait after each one, giving up early if ctx is done.
+func retryStart(ctx context.Context, attempts int, backoff time.Duration, start func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := start(attempt)
+		if err == nil {
+			return nil
+		}
+		last := attempt >= attempts || !isTransientStartError(err)
+		if last && attempt == 1 {
+			return err
+		}
+		if last {
+			return fmt.Errorf("start CLI: %d attempts failed: %w", attempt, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(ctx.Err(), fmt.Errorf("start CLI: %d attempts failed: %w", attempt, err))
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// cloneCmd returns an unstarted copy of cmd, whose pipes must be set up again.
+func cloneCmd(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	clone := exec.CommandContext(ctx, cmd.Args[0], cmd.Args[1:]...)
+	clone.Env = cmd.Env
+	clone.Dir = cmd.Dir
+	clone.SysProcAttr = cmd.SysProcAttr
+	clone.Stderr = cmd.Stderr
+	clone.WaitDelay = cmd.WaitDelay
+	return clone
+}
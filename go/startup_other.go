@@ -0,0 +1,9 @@
+//go:build !unix
+
+package kimi
+
+// isTransientStartError reports whether starting a process failed for a
+// transient reason. Outside Unix no failure is known to be transient.
+func isTransientStartError(err error) bool {
+	return false
+}
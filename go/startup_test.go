@@ -0,0 +1,142 @@
+//go:build unix
+
+package kimi
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingStart returns a start function that fails with err the first k
+// times it is called, and records how often it was.
+func failingStart(k int, err error, calls *int) func(int) error {
+	return func(attempt int) error {
+		*calls++
+		if attempt != *calls {
+			panic("attempts out of order")
+		}
+		if *calls <= k {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestRetryStart(t *testing.T) {
+	transient := &exec.Error{Name: "kimi", Err: syscall.EAGAIN}
+	permanent := exec.ErrNotFound
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   string
+	}{
+		{"succeeds first time", 3, 0, transient, 1, ""},
+		{"recovers", 3, 2, transient, 3, ""},
+		{"exhausted", 3, 5, transient, 3, "3 attempts failed"},
+		{"no retry by default", 1, 5, transient, 1, "resource temporarily unavailable"},
+		{"permanent", 3, 5, permanent, 1, "executable file not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			err := retryStart(context.Background(), tt.attempts, time.Millisecond, failingStart(tt.failures, tt.err, &calls))
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d calls, got %d", tt.wantCalls, calls)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected error to wrap %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRetryStart_PermanentAfterTransient(t *testing.T) {
+	calls := 0
+	err := retryStart(context.Background(), 5, time.Millisecond, func(int) error {
+		calls++
+		if calls == 1 {
+			return syscall.ENOMEM
+		}
+		return exec.ErrNotFound
+	})
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+	if !errors.Is(err, exec.ErrNotFound) || !strings.Contains(err.Error(), "2 attempts failed") {
+		t.Fatalf("expected the permanent error after 2 attempts, got %v", err)
+	}
+}
+
+func TestRetryStart_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := retryStart(ctx, 5, time.Hour, failingStart(5, syscall.EAGAIN, &calls))
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("expected the cancellation and the start error, got %v", err)
+	}
+}
+
+func TestNewSession_StartupRetryNotFound(t *testing.T) {
+	start := time.Now()
+	_, err := NewSession(WithExecutable("kimi-does-not-exist"), WithStartupRetry(5, time.Hour))
+	if !errors.Is(err, ErrExecutableNotFound) {
+		t.Fatalf("expected ErrExecutableNotFound, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("a missing executable was retried, NewSession took %v", elapsed)
+	}
+}
+
+func TestNewSession_InvalidStartupRetry(t *testing.T) {
+	for _, option := range []Option{
+		WithStartupRetry(0, time.Second),
+		WithStartupRetry(3, -time.Second),
+	} {
+		if _, err := NewSession(WithExecutable("kimi-does-not-exist"), option); err == nil || errors.Is(err, ErrExecutableNotFound) {
+			t.Errorf("expected an invalid startup retry error, got %v", err)
+		}
+	}
+}
+
+func TestCloneCmd(t *testing.T) {
+	stderr := &stderrTail{}
+	cmd := exec.Command("kimi-does-not-exist", "--wire")
+	cmd.Env = []string{"A=1"}
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err == nil {
+		t.Fatal("expected Start to fail")
+	}
+	clone := cloneCmd(context.Background(), cmd)
+	if !reflect.DeepEqual(clone.Args, cmd.Args) || !reflect.DeepEqual(clone.Env, cmd.Env) {
+		t.Errorf("expected args %v and env %v, got %v and %v", cmd.Args, cmd.Env, clone.Args, clone.Env)
+	}
+	if clone.Stderr != stderr || clone.WaitDelay != time.Second {
+		t.Errorf("expected stderr and wait delay to be copied")
+	}
+	if _, err := clone.StdinPipe(); err != nil {
+		t.Errorf("expected the clone to accept new pipes, got %v", err)
+	}
+}
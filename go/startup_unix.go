@@ -0,0 +1,22 @@
+//go:build unix
+
+package kimi
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isTransientStartError reports whether starting a process failed because
+// the system was temporarily out of resources, so trying again may succeed.
+func isTransientStartError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EAGAIN, syscall.ENOMEM, syscall.EMFILE, syscall.ENFILE:
+		return true
+	}
+	return false
+}
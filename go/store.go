@@ -0,0 +1,222 @@
+package kimi
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionMeta describes a session recorded in a SessionStore.
+type SessionMeta struct {
+	// ID is the CLI session ID, to be passed to WithSession.
+	ID string `json:"id"`
+	// Model is the model given with WithModel, or the default model of the
+	// Config given with WithConfig, or "" if the CLI chose it.
+	Model string `json:"model,omitempty"`
+	// WorkDir is the absolute path of the directory the CLI ran in.
+	WorkDir string `json:"work_dir"`
+	// CreatedAt is when the session was first started.
+	CreatedAt time.Time `json:"created_at"`
+	// StartedAt is when the session was last started, whether new or
+	// resumed.
+	StartedAt time.Time `json:"started_at"`
+}
+
+// SessionStore records the sessions started with WithSessionStore, e.g. to
+// offer a list of recent conversations to resume. Its methods may be called
+// from several goroutines at once.
+type SessionStore interface {
+	// Save records meta as the metadata of session id, replacing any
+	// previous record.
+	Save(id string, meta SessionMeta) error
+	// Load returns the metadata of session id, or an error wrapping
+	// ErrSessionNotFound if there is none.
+	Load(id string) (SessionMeta, error)
+	// List returns the metadata of all recorded sessions.
+	List() ([]SessionMeta, error)
+}
+
+// WithSessionStore records every session started with the options in store:
+// its ID, model and work directory, and when it was created and last
+// started. A session resumed by giving WithSession the ID of a recorded one
+// runs in the recorded work directory and with the recorded model, unless
+// WithWorkDir or WithModel say otherwise, since the CLI only finds a session
+// in the work directory it was created in.
+//
+// NewSession fails if store cannot load the record of a resumed session for
+// any reason other than ErrSessionNotFound. A failure to save the record
+// once the session has started is logged, and the session is still returned.
+func WithSessionStore(store SessionStore) Option {
+	return func(opt *option) {
+		opt.sessionStore = store
+	}
+}
+
+// resumeStoredSession applies the recorded work directory and model of the
+// session opt resumes, and returns its record; the record is nil for a new or
+// unrecorded session.
+func resumeStoredSession(opt *option) (*SessionMeta, error) {
+	if opt.sessionStore == nil || opt.session == "" {
+		return nil, nil
+	}
+	meta, err := opt.sessionStore.Load(opt.session)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kimi: load session %s: %w", opt.session, err)
+	}
+	if opt.workDir == "" && meta.WorkDir != "" {
+		WithWorkDir(meta.WorkDir)(opt)
+	}
+	if opt.model == "" && meta.Model != "" {
+		WithModel(meta.Model)(opt)
+	}
+	return &meta, nil
+}
+
+// storedSessionMeta returns the record to save for a session started with
+// opt in workDir, keeping the creation time of the previous record if any.
+func storedSessionMeta(opt *option, workDir string, previous *SessionMeta) SessionMeta {
+	now := time.Now()
+	meta := SessionMeta{
+		ID:        opt.session,
+		Model:     opt.model,
+		WorkDir:   workDir,
+		CreatedAt: now,
+		StartedAt: now,
+	}
+	if opt.config != nil {
+		meta.Model = cmp.Or(meta.Model, opt.config.DefaultModel)
+	}
+	if previous != nil && !previous.CreatedAt.IsZero() {
+		meta.CreatedAt = previous.CreatedAt
+	}
+	return meta
+}
+
+// fileSessionStoreLocks serializes the FileSessionStores of the same file
+// across the process, keyed by absolute path.
+var fileSessionStoreLocks sync.Map
+
+// FileSessionStore is a SessionStore that keeps the records in a JSON file.
+// The file is created on the first Save and replaced atomically on every
+// Save, so a crash never leaves it half written. Stores of the same file in
+// one process share a lock; processes sharing a file may lose each other's
+// concurrent saves.
+type FileSessionStore struct {
+	path string
+	mu   *sync.Mutex
+}
+
+// NewFileSessionStore returns a FileSessionStore backed by the file at path.
+// A relative path is resolved against the current directory.
+func NewFileSessionStore(path string) *FileSessionStore {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	mu, _ := fileSessionStoreLocks.LoadOrStore(path, new(sync.Mutex))
+	return &FileSessionStore{path: path, mu: mu.(*sync.Mutex)}
+}
+
+type fileSessionStoreData struct {
+	Sessions []SessionMeta `json:"sessions"`
+}
+
+func (s *FileSessionStore) Save(id string, meta SessionMeta) error {
+	meta.ID = id
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions, err := s.read()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(sessions, func(m SessionMeta) bool { return m.ID == id })
+	if i < 0 {
+		sessions = append(sessions, meta)
+	} else {
+		sessions[i] = meta
+	}
+	return s.write(sessions)
+}
+
+func (s *FileSessionStore) Load(id string) (SessionMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions, err := s.read()
+	if err != nil {
+		return SessionMeta{}, err
+	}
+	i := slices.IndexFunc(sessions, func(m SessionMeta) bool { return m.ID == id })
+	if i < 0 {
+		return SessionMeta{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return sessions[i], nil
+}
+
+// List returns the recorded sessions, the most recently started first.
+func (s *FileSessionStore) List() ([]SessionMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(sessions, func(a, b SessionMeta) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+	return sessions, nil
+}
+
+// read returns the records in the file, or none if it does not exist.
+func (s *FileSessionStore) read() ([]SessionMeta, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var decoded fileSessionStoreData
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("kimi: decode session store %s: %w", s.path, err)
+	}
+	return decoded.Sessions, nil
+}
+
+// write replaces the file with sessions by renaming a temporary file over it.
+func (s *FileSessionStore) write(sessions []SessionMeta) error {
+	data, err := json.MarshalIndent(fileSessionStoreData{Sessions: sessions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), s.path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package kimi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewFileSessionStore(path)
+
+	if sessions, err := store.List(); err != nil || len(sessions) != 0 {
+		t.Fatalf("List() on a missing file = %v, %v, want none", sessions, err)
+	}
+	if _, err := store.Load("a"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := SessionMeta{Model: "kimi-k2", WorkDir: "/work/a", CreatedAt: created, StartedAt: created}
+	b := SessionMeta{WorkDir: "/work/b", CreatedAt: created, StartedAt: created.Add(time.Hour)}
+	if err := store.Save("a", a); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := store.Save("b", b); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	a.ID, b.ID = "a", "b"
+
+	// Another store of the same file sees the records.
+	got, err := NewFileSessionStore(path).Load("a")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(got, a) {
+		t.Errorf("Load() = %+v, want %+v", got, a)
+	}
+	sessions, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if want := []SessionMeta{b, a}; !reflect.DeepEqual(sessions, want) {
+		t.Errorf("List() = %+v, want %+v", sessions, want)
+	}
+
+	// Saving again replaces the record.
+	a.StartedAt = created.Add(2 * time.Hour)
+	if err := store.Save("a", a); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	sessions, err = store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if want := []SessionMeta{a, b}; !reflect.DeepEqual(sessions, want) {
+		t.Errorf("List() = %+v, want %+v", sessions, want)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the store file to be left, got %v", entries)
+	}
+}
+
+func TestFileSessionStore_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if err := NewFileSessionStore(path).Save(fmt.Sprint(i), SessionMeta{}); err != nil {
+				t.Errorf("Save: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	sessions, err := NewFileSessionStore(path).List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(sessions) != 20 {
+		t.Errorf("expected 20 sessions, got %d", len(sessions))
+	}
+}
+
+func TestFileSessionStore_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileSessionStore(path)
+	if _, err := store.Load("a"); err == nil || errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected a decoding error, got %v", err)
+	}
+	if err := store.Save("a", SessionMeta{}); err == nil {
+		t.Error("expected Save not to overwrite a corrupt file")
+	}
+}
+
+func TestResumeStoredSession(t *testing.T) {
+	store := NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.json"))
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	meta := SessionMeta{Model: "kimi-k2", WorkDir: "/work/a", CreatedAt: created, StartedAt: created}
+	if err := store.Save("a", meta); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := &option{exec: "kimi"}
+	WithSessionStore(store)(opt)
+	WithSession("a")(opt)
+	WithModel("kimi-k2-thinking")(opt)
+	previous, err := resumeStoredSession(opt)
+	if err != nil {
+		t.Fatalf("resumeStoredSession: %v", err)
+	}
+	if opt.workDir != "/work/a" {
+		t.Errorf("expected the recorded work dir, got %q", opt.workDir)
+	}
+	if opt.model != "kimi-k2-thinking" {
+		t.Errorf("expected WithModel to win over the recorded model, got %q", opt.model)
+	}
+	stored := storedSessionMeta(opt, "/work/a", previous)
+	if !stored.CreatedAt.Equal(created) || !stored.StartedAt.After(created) {
+		t.Errorf("expected the creation time to be kept, got %+v", stored)
+	}
+
+	// Unrecorded and new sessions are left alone.
+	for _, session := range []string{"b", ""} {
+		opt := &option{exec: "kimi", session: session, sessionStore: store}
+		if previous, err := resumeStoredSession(opt); previous != nil || err != nil || opt.workDir != "" {
+			t.Errorf("session %q: resumeStoredSession() = %v, %v with work dir %q", session, previous, err, opt.workDir)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+//go:build unix
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	kimi "github.com/MoonshotAI/kimi-agent-sdk/go"
+	"github.com/MoonshotAI/kimi-agent-sdk/go/kimitest"
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+func promptAndDrain(session *kimi.Session) error {
+	turn, err := session.Prompt(context.Background(), wire.NewStringContent("test"))
+	if err != nil {
+		return err
+	}
+	for step := range turn.Steps {
+		for range step.Messages {
+		}
+	}
+	return turn.Err()
+}
+
+// TestIntegration_WithInterruptOnSignal tests that a single signal keeps the
+// session usable, while two signals in quick succession close it.
+func TestIntegration_WithInterruptOnSignal(t *testing.T) {
+	kimitest.LeakCheck(t)
+	mockPath := getMockKimiPath(t)
+
+	var logs lockedBuffer
+	session, err := kimi.NewSession(
+		kimi.WithExecutable(mockPath),
+		kimi.WithInterruptOnSignal(syscall.SIGUSR1),
+		kimi.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := promptAndDrain(session); err != nil {
+		t.Fatalf("expected session to survive a single interrupt, got %v", err)
+	}
+
+	// Wait out the escalation window before sending the double interrupt.
+	time.Sleep(2 * time.Second)
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	time.Sleep(50 * time.Millisecond)
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	// LeakCheck verifies that closing the session stopped all its goroutines.
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "repeated interrupt, closing session") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected session to be closed after a repeated interrupt, logs:\n%s", logs.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestIntegration_Close_Shutdown(t *testing.T) {
+	mockPath := getMockKimiPath(t)
+
+	cases := []struct {
+		mode    string
+		timeout time.Duration
+		wantErr string
+	}{
+		{"normal", 5 * time.Second, ""},
+		{"ignore_sigterm", 200 * time.Millisecond, ""},
+		{"sigterm_exit_code", 5 * time.Second, "exit status 3"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			kimitest.LeakCheck(t)
+			session, err := kimi.NewSession(
+				kimi.WithExecutable(mockPath),
+				withMode(tc.mode),
+				kimi.WithShutdownTimeout(tc.timeout),
+			)
+			if err != nil {
+				t.Fatalf("NewSession: %v", err)
+			}
+			if err := promptAndDrain(session); err != nil {
+				t.Fatalf("prompt: %v", err)
+			}
+
+			start := time.Now()
+			err = session.Close()
+			if elapsed := time.Since(start); elapsed > tc.timeout+2*time.Second {
+				t.Errorf("Close took %v with a shutdown timeout of %v", elapsed, tc.timeout)
+			}
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("expected Close error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestIntegration_Prompt_ContextDeadline tests that a context deadline shuts
+// down a CLI that ignores the cancellation of its turn.
+func TestIntegration_Prompt_ContextDeadline(t *testing.T) {
+	kimitest.LeakCheck(t)
+	mockPath := getMockKimiPath(t)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	t.Setenv("MOCK_KIMI_PID_FILE", pidFile)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	turn, err := kimi.Prompt(ctx, wire.NewStringContent("test"),
+		kimi.WithExecutable(mockPath),
+		kimi.WithShutdownTimeout(500*time.Millisecond),
+		withMode("hang_prompt"),
+	)
+	if err != nil {
+		t.Fatalf("Prompt: %v", err)
+	}
+	defer turn.Close()
+
+	start := time.Now()
+	for step := range turn.Steps {
+		for range step.Messages {
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("turn took %v to end after the deadline", elapsed)
+	}
+	if err := turn.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read PID file: %v", err)
+	}
+	pid, _ := strconv.Atoi(string(data))
+	if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+		t.Errorf("expected CLI process %d to be reaped, got %v", pid, err)
+	}
+}
+
+// TestIntegration_Session_Healthy_Killed tests that Healthy reports a CLI
+// that died behind the session's back.
+func TestIntegration_Session_Healthy_Killed(t *testing.T) {
+	kimitest.LeakCheck(t)
+	mockPath := getMockKimiPath(t)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	t.Setenv("MOCK_KIMI_PID_FILE", pidFile)
+
+	session, err := kimi.NewSession(kimi.WithExecutable(mockPath))
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := session.Healthy(ctx); err != nil {
+		t.Fatalf("expected a healthy session, got %v", err)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read PID file: %v", err)
+	}
+	pid, _ := strconv.Atoi(string(data))
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("kill CLI: %v", err)
+	}
+	if err := session.Healthy(ctx); !errors.Is(err, kimi.ErrSessionDead) {
+		t.Fatalf("expected ErrSessionDead, got %v", err)
+	}
+}
+
+// TestIntegration_WithSystemPrompt_Killed checks that the temporary agent
+// file is removed when the CLI is killed rather than closed.
+func TestIntegration_WithSystemPrompt_Killed(t *testing.T) {
+	kimitest.LeakCheck(t)
+	mockPath := getMockKimiPath(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	t.Setenv("MOCK_KIMI_PID_FILE", pidFile)
+
+	session, err := kimi.NewSession(
+		kimi.WithExecutable(mockPath),
+		kimi.WithSystemPrompt("You are a release bot."),
+		kimi.WithConfig(&kimi.Config{}),
+	)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	if entries, _ := os.ReadDir(tmp); len(entries) != 1 {
+		t.Fatalf("expected one temporary agent directory, got %v", entries)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read PID file: %v", err)
+	}
+	pid, _ := strconv.Atoi(string(data))
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("kill CLI: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := session.Healthy(ctx); !errors.Is(err, kimi.ErrSessionDead) {
+		t.Fatalf("expected ErrSessionDead, got %v", err)
+	}
+	// A broken pipe can tell Healthy before the session has seen the exit.
+	for {
+		entries, _ := os.ReadDir(tmp)
+		if len(entries) == 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("expected the agent file to be removed once the CLI was killed, found %v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestIntegration_ExitError_Signal checks that a CLI killed behind the
+// session's back is reported as an ExitError with the stderr tail.
+func TestIntegration_ExitError_Signal(t *testing.T) {
+	kimitest.LeakCheck(t)
+	mockPath := getMockKimiPath(t)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	t.Setenv("MOCK_KIMI_PID_FILE", pidFile)
+
+	session, err := kimi.NewSession(kimi.WithExecutable(mockPath))
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read PID file: %v", err)
+	}
+	pid, _ := strconv.Atoi(string(data))
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("kill CLI: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var exitErr *kimi.ExitError
+	for {
+		err = session.Healthy(ctx)
+		if errors.As(err, &exitErr) || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exitErr == nil {
+		t.Fatalf("expected an ExitError, got %v", err)
+	}
+	if exitErr.ExitCode != -1 || exitErr.NotFound {
+		t.Errorf("expected exit code -1, got %+v", exitErr)
+	}
+	if exitErr.Stderr != "mock kimi started" {
+		t.Errorf("expected the stderr tail, got %q", exitErr.Stderr)
+	}
+	if !strings.Contains(err.Error(), "signal: killed") {
+		t.Errorf("expected the error to name the signal, got %v", err)
+	}
+}
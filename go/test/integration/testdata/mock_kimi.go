@@ -11,8 +11,19 @@
 //   flood - sends many events rapidly
 //   prompt_error - sends TurnBegin then returns a JSONRPC error
 //   tool_call - sends ToolCall request and waits for response
+//   approval - sends ApprovalRequest, waits for response and echoes it as text
 //   tool_rejected - returns rejected external tools in initialize response
 //   turn_end - sends TurnEnd event to explicitly end the turn
+//   hang_initialize - never responds to initialize
+//   ignore_sigterm - ignores SIGTERM, so it has to be killed
+//   sigterm_exit_code - exits with status 3 on SIGTERM
+//   crash - writes to stderr and exits with status 2 on initialize
+//   crash_on_prompt - writes to stderr and exits with status 2 on prompt
+//   hang_prompt - starts a turn but never completes the prompt, even when cancelled
+//   wait_cancel - starts a turn and completes the prompt as cancelled once cancelled
+//
+// If MOCK_KIMI_PID_FILE is set, the --wire process writes its PID to that file
+// and "mock kimi started" to stderr.
 
 package main
 
@@ -21,7 +32,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync/atomic"
+	"syscall"
 )
 
 var (
@@ -61,7 +75,13 @@ func main() {
 
 	// Handle info command
 	if hasInfo {
-		fmt.Println(`{"wire_protocol_version": "2"}`)
+		// MOCK_KIMI_WIRE_VERSION overrides the wire protocol version, to
+		// test the SDK's compatibility check.
+		version := "1.7"
+		if v, ok := os.LookupEnv("MOCK_KIMI_WIRE_VERSION"); ok {
+			version = v
+		}
+		fmt.Printf("{\"kimi_cli_version\": \"0.0.1\", \"wire_protocol_version\": %q}\n", version)
 		os.Exit(0)
 	}
 
@@ -70,8 +90,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	if os.Getenv("MOCK_KIMI_CANARY") != "" {
+		fmt.Fprintln(os.Stderr, "MOCK_KIMI_CANARY leaked into the environment")
+		os.Exit(1)
+	}
+
+	if path := os.Getenv("MOCK_KIMI_PID_FILE"); path != "" {
+		os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o600)
+		fmt.Fprintln(os.Stderr, "mock kimi started")
+	}
+
+	switch mode {
+	case "ignore_sigterm":
+		signal.Ignore(syscall.SIGTERM)
+	case "sigterm_exit_code":
+		terms := make(chan os.Signal, 1)
+		signal.Notify(terms, syscall.SIGTERM)
+		go func() {
+			<-terms
+			os.Exit(3)
+		}()
+	}
+
 	scanner := bufio.NewScanner(os.Stdin)
 	encoder := json.NewEncoder(os.Stdout)
+	// pendingPrompt is the ID of the prompt a wait_cancel turn is running.
+	var pendingPrompt string
 
 	for scanner.Scan() {
 		var req Payload
@@ -81,17 +125,36 @@ func main() {
 
 		switch req.Method {
 		case "initialize":
+			if mode == "hang_initialize" {
+				continue
+			}
+			if mode == "crash" {
+				crash()
+			}
 			handleInitialize(encoder, req.ID)
 		case "prompt":
 			switch mode {
+			case "crash_on_prompt":
+				crash()
+			case "hang_prompt":
+				sendEvent(encoder, "TurnBegin", map[string]any{"user_input": "test"})
+				sendEvent(encoder, "StepBegin", map[string]any{"n": 1})
+			case "wait_cancel":
+				sendEvent(encoder, "TurnBegin", map[string]any{"user_input": "test"})
+				sendEvent(encoder, "StepBegin", map[string]any{"n": 1})
+				pendingPrompt = req.ID
 			case "deadlock":
 				handlePromptDeadlock(encoder, req.ID)
 			case "flood":
 				handlePromptFlood(encoder, req.ID)
 			case "prompt_error":
-				handlePromptError(encoder, req.ID)
+				handlePromptError(encoder, req.ID, `{"code":-32000,"message":"simulated prompt error"}`)
+			case "auth_error":
+				handlePromptError(encoder, req.ID, `{"code":-32003,"message":"Error code: 401 - {'error': {'message': 'Invalid Authentication', 'type': 'invalid_authentication_error'}}"}`)
 			case "tool_call":
 				handlePromptToolCall(encoder, scanner, req.ID)
+			case "approval":
+				handlePromptApproval(encoder, scanner, req.ID)
 			case "turn_end":
 				handlePromptTurnEnd(encoder, req.ID)
 			default:
@@ -99,15 +162,30 @@ func main() {
 			}
 		case "cancel":
 			handleCancel(encoder, req.ID)
+			if pendingPrompt != "" {
+				sendEvent(encoder, "StepInterrupted", map[string]any{})
+				encoder.Encode(Payload{
+					Version: "2.0",
+					ID:      pendingPrompt,
+					Result:  json.RawMessage(`{"status":"cancelled","steps":1}`),
+				})
+				pendingPrompt = ""
+			}
 		}
 	}
 }
 
+func crash() {
+	fmt.Fprintln(os.Stderr, "loading config")
+	fmt.Fprintln(os.Stderr, "error: model \"missing\" not found")
+	os.Exit(2)
+}
+
 func handleInitialize(encoder *json.Encoder, reqID string) {
 	var result json.RawMessage
 	if mode == "tool_rejected" {
 		result = json.RawMessage(`{
-			"protocol_version": "2",
+			"protocol_version": "1.7",
 			"server": {"name": "mock_kimi", "version": "0.0.1"},
 			"slash_commands": [],
 			"external_tools": {
@@ -117,7 +195,7 @@ func handleInitialize(encoder *json.Encoder, reqID string) {
 		}`)
 	} else {
 		result = json.RawMessage(`{
-			"protocol_version": "2",
+			"protocol_version": "1.7",
 			"server": {"name": "mock_kimi", "version": "0.0.1"},
 			"slash_commands": []
 		}`)
@@ -207,6 +285,50 @@ func sendRequest(encoder *json.Encoder, requestType string, payload any) {
 	})
 }
 
+// handlePromptApproval sends an ApprovalRequest, waits for the SDK's response
+// and reports the decision in a ContentPart.
+func handlePromptApproval(encoder *json.Encoder, scanner *bufio.Scanner, reqID string) {
+	sendEvent(encoder, "TurnBegin", map[string]any{
+		"user_input": "test",
+	})
+	sendEvent(encoder, "StepBegin", map[string]any{
+		"n": 1,
+	})
+	approvalReqID := fmt.Sprintf("req-%d", requestID.Load()+1)
+	sendRequest(encoder, "ApprovalRequest", map[string]any{
+		"id":           "approval-1",
+		"tool_call_id": "call-123",
+		"sender":       "Shell",
+		"action":       "run shell command",
+		"description":  "rm -rf build",
+	})
+
+	// Skip the acknowledgements of the events above.
+	response := "none"
+	for scanner.Scan() {
+		var resp struct {
+			ID     string `json:"id"`
+			Result struct {
+				Response string `json:"response"`
+			} `json:"result"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &resp) == nil && resp.ID == approvalReqID {
+			response = resp.Result.Response
+			break
+		}
+	}
+	sendEvent(encoder, "ContentPart", map[string]any{
+		"type": "text",
+		"text": response,
+	})
+	sendEvent(encoder, "TurnEnd", map[string]any{})
+	encoder.Encode(Payload{
+		Version: "2.0",
+		ID:      reqID,
+		Result:  json.RawMessage(`{"status":"finished","steps":1}`),
+	})
+}
+
 // handlePromptDeadlock sends an ApprovalRequest then immediately completes the prompt
 // This tests whether Request method holding RLock while waiting for usrc can deadlock
 // with cleanup trying to acquire write lock
@@ -266,9 +388,9 @@ func handlePromptFlood(encoder *json.Encoder, reqID string) {
 	})
 }
 
-// handlePromptError sends TurnBegin then returns a JSONRPC error.
+// handlePromptError sends TurnBegin then returns the JSONRPC error rpcErr.
 // This tests whether turn.Err() correctly captures the error after TurnBegin.
-func handlePromptError(encoder *json.Encoder, reqID string) {
+func handlePromptError(encoder *json.Encoder, reqID string, rpcErr string) {
 	// Send TurnBegin event first
 	sendEvent(encoder, "TurnBegin", map[string]any{
 		"user_input": "test",
@@ -283,7 +405,7 @@ func handlePromptError(encoder *json.Encoder, reqID string) {
 	encoder.Encode(Payload{
 		Version: "2.0",
 		ID:      reqID,
-		Error:   json.RawMessage(`{"code":-32000,"message":"simulated prompt error"}`),
+		Error:   json.RawMessage(rpcErr),
 	})
 }
 
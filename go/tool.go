@@ -1,11 +1,16 @@
 package kimi
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
 )
@@ -125,6 +130,129 @@ func CreateTool[T any, U any](function func(T) (U, error), options ...ToolOption
 	return Tool{call: fn, def: def}, nil
 }
 
+// toolCache memoizes successful results of cacheable tools within a session,
+// keyed by tool name and a hash of the canonicalized arguments.
+type toolCache struct {
+	mu      sync.Mutex
+	names   map[string]struct{}
+	results map[string]string
+}
+
+func newToolCache(names []string) *toolCache {
+	if len(names) == 0 {
+		return nil
+	}
+	cache := &toolCache{
+		names:   make(map[string]struct{}, len(names)),
+		results: make(map[string]string),
+	}
+	for _, name := range names {
+		cache.names[name] = struct{}{}
+	}
+	return cache
+}
+
+func (c *toolCache) key(name string, args string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	if _, ok := c.names[name]; !ok {
+		return "", false
+	}
+	// Canonicalize arguments so that semantically identical calls, which differ
+	// only in whitespace or key order, share a cache entry. Numbers are kept
+	// as written so that large integers do not collide after rounding to
+	// float64.
+	canonical := []byte(args)
+	decoder := json.NewDecoder(strings.NewReader(args))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err == nil && decoder.Decode(new(any)) == io.EOF {
+		if data, err := json.Marshal(value); err == nil {
+			canonical = data
+		}
+	}
+	sum := sha256.Sum256(canonical)
+	return name + ":" + hex.EncodeToString(sum[:]), true
+}
+
+func (c *toolCache) load(name string, args string) (string, bool) {
+	key, ok := c.key(name, args)
+	if !ok {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[key]
+	return result, ok
+}
+
+func (c *toolCache) store(name string, args string, result string) {
+	key, ok := c.key(name, args)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[key] = result
+}
+
+func (c *toolCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.results)
+}
+
+// toolOutputPreviewSize is how much of an output written to a tool output
+// sink is still returned to the model.
+const toolOutputPreviewSize = 1024
+
+// sinkToolOutput writes output to the writer the sink opens for the call and
+// returns what should be fed back to the model instead. ok is false if the
+// sink declined the call by returning a nil writer.
+func sinkToolOutput(
+	sink func(toolName, callID string) (io.WriteCloser, error),
+	toolName, callID, output string,
+) (summary string, ok bool, err error) {
+	w, err := sink(toolName, callID)
+	if err != nil || w == nil {
+		return "", false, err
+	}
+	_, err = io.WriteString(w, output)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return toolOutputSummary(w, output), true, nil
+}
+
+// toolOutputSummary returns output unchanged if it fits in
+// toolOutputPreviewSize, and otherwise its first toolOutputPreviewSize bytes
+// (cut at a rune boundary) followed by a note on where the rest went. Only
+// the rune at the cut is backed out of, so output that is not UTF-8, such as
+// binary data, still gets a full preview.
+func toolOutputSummary(w io.Writer, output string) string {
+	if len(output) <= toolOutputPreviewSize {
+		return output
+	}
+	cut := toolOutputPreviewSize
+	for cut > toolOutputPreviewSize-(utf8.UTFMax-1) && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	preview := output[:cut]
+	where := "to the tool output sink"
+	if named, ok := w.(interface{ Name() string }); ok {
+		where = "to " + named.Name()
+	}
+	return fmt.Sprintf("%s\n[output truncated: showing %d of %d bytes, full output written %s]",
+		preview, len(preview), len(output), where)
+}
+
 func stringifyResult(result any) (string, error) {
 	switch v := result.(type) {
 	case string:
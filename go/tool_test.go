@@ -2,7 +2,11 @@ package kimi
 
 import (
 	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -758,3 +762,101 @@ func TestGenerateSchema_ComplexStruct(t *testing.T) {
 		t.Errorf("schema mismatch:\ngot:  %s\nwant: %s", got, expected)
 	}
 }
+
+type nopWriteCloser struct {
+	strings.Builder
+	closed bool
+}
+
+func (w *nopWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestSinkToolOutput(t *testing.T) {
+	w := &nopWriteCloser{}
+	output := strings.Repeat("a", toolOutputPreviewSize-1) + "é" + strings.Repeat("b", 100)
+	summary, ok, err := sinkToolOutput(func(toolName, callID string) (io.WriteCloser, error) {
+		if toolName != "search" || callID != "call-1" {
+			t.Errorf("unexpected sink arguments %q, %q", toolName, callID)
+		}
+		return w, nil
+	}, "search", "call-1", output)
+	if err != nil || !ok {
+		t.Fatalf("sinkToolOutput: ok=%v err=%v", ok, err)
+	}
+	if w.String() != output || !w.closed {
+		t.Fatal("expected full output to be written and the writer closed")
+	}
+	// The two-byte 'é' straddles the preview limit and must not be split.
+	wantPrefix := strings.Repeat("a", toolOutputPreviewSize-1) + "\n[output truncated: showing 1023 of 1125 bytes"
+	if !strings.HasPrefix(summary, wantPrefix) {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestSinkToolOutput_Binary(t *testing.T) {
+	// Invalid UTF-8 at the start must not empty the preview.
+	output := "\xff\xfe" + strings.Repeat("\x00", 2*toolOutputPreviewSize)
+	summary, ok, err := sinkToolOutput(func(string, string) (io.WriteCloser, error) {
+		return &nopWriteCloser{}, nil
+	}, "dump", "call-1", output)
+	if err != nil || !ok {
+		t.Fatalf("sinkToolOutput: ok=%v err=%v", ok, err)
+	}
+	wantPrefix := output[:toolOutputPreviewSize] + "\n[output truncated: showing 1024 of 2050 bytes"
+	if !strings.HasPrefix(summary, wantPrefix) {
+		t.Errorf("expected a full preview, got %q", summary[:min(len(summary), 80)])
+	}
+}
+
+func TestSinkToolOutput_Short(t *testing.T) {
+	summary, ok, err := sinkToolOutput(func(string, string) (io.WriteCloser, error) {
+		return &nopWriteCloser{}, nil
+	}, "search", "call-1", "small")
+	if err != nil || !ok || summary != "small" {
+		t.Fatalf("expected short output unchanged, got %q ok=%v err=%v", summary, ok, err)
+	}
+}
+
+func TestSinkToolOutput_Declined(t *testing.T) {
+	_, ok, err := sinkToolOutput(func(string, string) (io.WriteCloser, error) {
+		return nil, nil
+	}, "search", "call-1", "output")
+	if err != nil || ok {
+		t.Fatalf("expected declined sink, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSinkToolOutput_NamedWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	output := strings.Repeat("x", 2*toolOutputPreviewSize)
+	summary, _, err := sinkToolOutput(func(string, string) (io.WriteCloser, error) {
+		return os.Create(path)
+	}, "search", "call-1", output)
+	if err != nil {
+		t.Fatalf("sinkToolOutput: %v", err)
+	}
+	if !strings.HasSuffix(summary, "full output written to "+path+"]") {
+		t.Errorf("expected summary to reference %s, got %q", path, summary[len(summary)-80:])
+	}
+	if data, _ := os.ReadFile(path); string(data) != output {
+		t.Error("expected full output in file")
+	}
+}
+
+func TestToolCache_Key(t *testing.T) {
+	cache := newToolCache([]string{"lookup"})
+
+	a, _ := cache.key("lookup", `{"id": 1, "name": "x"}`)
+	b, _ := cache.key("lookup", `{"name":"x","id":1}`)
+	if a != b {
+		t.Errorf("expected equivalent arguments to share a key, got %q and %q", a, b)
+	}
+
+	a, _ = cache.key("lookup", `{"id":9007199254740993}`)
+	b, _ = cache.key("lookup", `{"id":9007199254740992}`)
+	if a == b {
+		t.Errorf("expected distinct large integers to get distinct keys, both got %q", a)
+	}
+}
@@ -0,0 +1,86 @@
+package kimi
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+// shareDir returns the directory the CLI keeps its data in, given the
+// environment it runs with: KIMI_SHARE_DIR, or ~/.kimi.
+func shareDir(env []string) (string, error) {
+	// As with exec.Cmd, the last value wins.
+	for _, kv := range slices.Backward(env) {
+		if value, ok := strings.CutPrefix(kv, "KIMI_SHARE_DIR="); ok {
+			if value != "" {
+				return value, nil
+			}
+			break
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".kimi"), nil
+}
+
+// sessionDir returns the directory the CLI stores the session id started in
+// workDir in. Sessions are grouped by the MD5 of the absolute work directory.
+func sessionDir(share, workDir, id string) string {
+	sum := md5.Sum([]byte(workDir))
+	return filepath.Join(share, "sessions", hex.EncodeToString(sum[:]), id)
+}
+
+// readWireFile reads the events recorded in a session's wire.jsonl, in
+// order. A missing file yields no events. Lines that are not event records,
+// such as the metadata header, requests or a line still being written, are
+// skipped.
+func readWireFile(ctx context.Context, path string) ([]wire.Message, error) {
+	messages := []wire.Message{}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return messages, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var record struct {
+			Message json.RawMessage `json:"message"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Message == nil {
+			continue
+		}
+		// Requests are recorded too, and would otherwise decode as
+		// unknown events.
+		if json.Unmarshal(record.Message, new(wire.RequestParams)) == nil {
+			continue
+		}
+		var params wire.EventParams
+		if json.Unmarshal(record.Message, &params) != nil {
+			continue
+		}
+		messages = append(messages, params.Payload)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
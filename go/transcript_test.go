@@ -0,0 +1,64 @@
+package kimi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
+)
+
+func TestShareDir(t *testing.T) {
+	got, err := shareDir([]string{"KIMI_SHARE_DIR=/old", "KIMI_SHARE_DIR=/srv/kimi"})
+	if err != nil || got != "/srv/kimi" {
+		t.Errorf("expected the last KIMI_SHARE_DIR, got %q, %v", got, err)
+	}
+	t.Setenv("HOME", "/home/agent")
+	if got, err := shareDir(nil); err != nil || got != filepath.Join("/home/agent", ".kimi") {
+		t.Errorf("expected ~/.kimi, got %q, %v", got, err)
+	}
+}
+
+func TestSessionDir(t *testing.T) {
+	// The CLI names the directory after the MD5 of the work directory.
+	got := sessionDir("/srv/kimi", "/work", "abc")
+	want := filepath.Join("/srv/kimi", "sessions", "1e0bb3bee2d09d2e4ad3523530d3b40c", "abc")
+	if got != want {
+		t.Errorf("sessionDir() = %q, want %q", got, want)
+	}
+}
+
+func TestReadWireFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wire.jsonl")
+	data := `{"type": "metadata", "protocol_version": "1.1"}
+{"timestamp": 1.0, "message": {"type": "TurnBegin", "payload": {"user_input": "hi"}}}
+{"timestamp": 1.1, "message": {"type": "ContentPart", "payload": {"type": "text", "text": "hello"}}}
+{"timestamp": 1.2, "message": {"type": "ApprovalRequest", "payload": {"id": "1"}}}
+
+{"timestamp": 1.3, "message": {"type": "TurnEnd", "payload": {}}}
+{"timestamp": 1.4, "message": {"type": "StepBe`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readWireFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("readWireFile: %v", err)
+	}
+	want := []wire.Message{
+		wire.TurnBegin{UserInput: wire.NewStringContent("hi")},
+		wire.NewTextContentPart("hello"),
+		wire.TurnEnd{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readWireFile() = %#v, want %#v", got, want)
+	}
+}
+
+func TestReadWireFile_Missing(t *testing.T) {
+	got, err := readWireFile(context.Background(), filepath.Join(t.TempDir(), "wire.jsonl"))
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("expected an empty history, got %#v, %v", got, err)
+	}
+}
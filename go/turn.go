@@ -4,14 +4,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/MoonshotAI/kimi-agent-sdk/go/wire"
 	"github.com/MoonshotAI/kimi-agent-sdk/go/wire/transport"
 )
 
 var (
-	ErrTurnNotFound = errors.New("turn not found")
+	ErrTurnNotFound    = errors.New("turn not found")
+	ErrDuplicateTurnID = errors.New("duplicate turn ID")
+	ErrTurnInProgress  = errors.New("another turn is in progress")
+	// ErrFirstTokenTimeout is returned by Turn.Err for a turn cancelled
+	// because no text arrived within the WithFirstTokenDeadline deadline.
+	ErrFirstTokenTimeout = errors.New("first token deadline exceeded")
+	// ErrTurnTimeout is returned by Turn.Err for a turn cancelled because it
+	// ran longer than the WithTurnTimeout timeout.
+	ErrTurnTimeout = errors.New("turn timeout exceeded")
+	// ErrUnknownEvent is returned by Turn.Err for a turn ended because the
+	// CLI sent an event of a type the SDK does not know, with WithStrictWire.
+	ErrUnknownEvent = errors.New("unknown event type")
 )
 
 func turnBegin(
@@ -24,6 +42,7 @@ func turnBegin(
 	wireMessageChan <-chan wire.Message,
 	wireRequestResponseChan chan<- wire.RequestResponse,
 	exit func(error) error,
+	options ...turnOption,
 ) *Turn {
 	parent, cancel := context.WithCancel(ctx)
 	current, stop := context.WithCancel(context.Background())
@@ -31,6 +50,7 @@ func turnBegin(
 	steps := make(chan *Step)
 	turn := &Turn{
 		id:                      id,
+		ctx:                     ctx,
 		tp:                      tp,
 		errorPointer:            errorPointer,
 		resultPointer:           resultPointer,
@@ -41,8 +61,13 @@ func turnBegin(
 		wireProtocolVersion:     wireProtocolVersion,
 		wireRequestResponseChan: wireRequestResponseChan,
 		Steps:                   steps,
+		events:                  make(chan wire.Event),
+		switching:               make(chan struct{}),
 	}
 	turn.usage.Store(&Usage{})
+	for _, apply := range options {
+		apply(turn)
+	}
 	go turn.traverse(wireMessageChan, steps)
 	go turn.watch(parent)
 	return turn
@@ -50,10 +75,15 @@ func turnBegin(
 
 type Turn struct {
 	id            uint64
+	externalID    string
+	metadata      map[string]string
+	metadataErr   atomic.Pointer[metadataErr]
 	tp            transport.Transport
 	errorPointer  *atomic.Pointer[error]
 	resultPointer *atomic.Pointer[wire.PromptResult]
 
+	// ctx is the context passed to Session.Prompt.
+	ctx     context.Context
 	current context.Context
 	stop    context.CancelFunc
 	cancel  context.CancelFunc
@@ -64,6 +94,181 @@ type Turn struct {
 
 	wireProtocolVersion     string
 	wireRequestResponseChan chan<- wire.RequestResponse
+
+	hooks    []func(*Turn)
+	finished atomic.Bool
+
+	timeoutHooks []func(*Turn)
+	timedOut     atomic.Bool
+
+	compactionCallbacks []func(wire.CompactionEnd)
+	contextPressure     *contextPressure
+	strictWire          bool
+
+	coalesceWindow     time.Duration
+	firstTokenDeadline time.Duration
+	notices            []wire.Event
+
+	events     chan wire.Event
+	switching  chan struct{}
+	switchOnce sync.Once
+}
+
+type turnOption func(*Turn)
+
+// PromptOption configures a single turn started by Session.Prompt.
+type PromptOption func(*promptOption)
+
+type promptOption struct {
+	turnID   string
+	metadata map[string]string
+}
+
+// WithTurnID attaches the caller's own id to the turn, e.g. to correlate it
+// with upstream request logs; Turn.ExternalID returns it. IDs must be unique
+// within a session: Session.Prompt fails with ErrDuplicateTurnID for an ID
+// already used by an earlier turn.
+func WithTurnID(id string) PromptOption {
+	return func(opt *promptOption) {
+		opt.turnID = id
+	}
+}
+
+// WithTurnMetadata attaches key/value metadata to the turn, e.g. the tenant
+// and request it belongs to, to correlate the SDK's activity with the
+// caller's own tracing. Turn.Metadata returns it, the SDK's log records for
+// the turn carry it in a "metadata" group (see WithLogger), and errors
+// returned by Session.Prompt and Turn.Err are wrapped in a MetadataError
+// holding it. The wire protocol has no way to pass it on to the CLI.
+// Repeated options are merged, later keys winning.
+func WithTurnMetadata(metadata map[string]string) PromptOption {
+	return func(opt *promptOption) {
+		if len(metadata) == 0 {
+			return
+		}
+		if opt.metadata == nil {
+			opt.metadata = make(map[string]string, len(metadata))
+		}
+		maps.Copy(opt.metadata, metadata)
+	}
+}
+
+// MetadataError wraps an error of a turn started with WithTurnMetadata,
+// adding the metadata to its message. Use errors.As to get the metadata back
+// from an error returned by Session.Prompt or Turn.Err.
+type MetadataError struct {
+	Metadata map[string]string
+
+	err error
+}
+
+func (e *MetadataError) Error() string {
+	pairs := make([]string, 0, len(e.Metadata))
+	for _, key := range slices.Sorted(maps.Keys(e.Metadata)) {
+		pairs = append(pairs, key+"="+e.Metadata[key])
+	}
+	return fmt.Sprintf("%v (turn metadata: %s)", e.err, strings.Join(pairs, ", "))
+}
+
+func (e *MetadataError) Unwrap() error {
+	return e.err
+}
+
+// turnMetadataKey is the context key of the metadata of the turn a
+// Session.Prompt context belongs to.
+type turnMetadataKey struct{}
+
+// metadataLogAttrs returns the log attributes for the metadata of the turn
+// ctx belongs to, if any.
+func metadataLogAttrs(ctx context.Context) []any {
+	if ctx == nil {
+		return nil
+	}
+	metadata, _ := ctx.Value(turnMetadataKey{}).(map[string]string)
+	if len(metadata) == 0 {
+		return nil
+	}
+	attrs := make([]any, 0, 2*len(metadata))
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		attrs = append(attrs, key, metadata[key])
+	}
+	return []any{slog.Group("metadata", attrs...)}
+}
+
+// withMetadata sets the metadata returned by Turn.Metadata.
+func withMetadata(metadata map[string]string) turnOption {
+	return func(t *Turn) {
+		t.metadata = metadata
+	}
+}
+
+// withExternalID sets the ID returned by Turn.ExternalID.
+func withExternalID(id string) turnOption {
+	return func(t *Turn) {
+		t.externalID = id
+	}
+}
+
+// withTurnHook registers a hook that runs once the turn has completed.
+func withTurnHook(hook func(*Turn)) turnOption {
+	return func(t *Turn) {
+		t.hooks = append(t.hooks, hook)
+	}
+}
+
+// withCompactionCallbacks registers callbacks that run for every
+// CompactionEnd of the turn, before it is delivered.
+func withCompactionCallbacks(callbacks []func(wire.CompactionEnd)) turnOption {
+	return func(t *Turn) {
+		t.compactionCallbacks = callbacks
+	}
+}
+
+// withContextPressure reports the context usage of the turn's status updates
+// to p.
+func withContextPressure(p *contextPressure) turnOption {
+	return func(t *Turn) {
+		t.contextPressure = p
+	}
+}
+
+// withStrictWire ends the turn with ErrUnknownEvent on the first
+// wire.UnknownEvent, instead of delivering it.
+func withStrictWire() turnOption {
+	return func(t *Turn) {
+		t.strictWire = true
+	}
+}
+
+// withCoalesceWindow merges consecutive text ContentParts arriving within
+// window of the first one into a single ContentPart.
+func withCoalesceWindow(window time.Duration) turnOption {
+	return func(t *Turn) {
+		t.coalesceWindow = window
+	}
+}
+
+// withTimeoutHook registers a hook that runs when a timeout of the turn fires,
+// before the turn is cancelled.
+func withTimeoutHook(hook func(*Turn)) turnOption {
+	return func(t *Turn) {
+		t.timeoutHooks = append(t.timeoutHooks, hook)
+	}
+}
+
+// withFirstTokenDeadline cancels the turn with ErrFirstTokenTimeout unless
+// text or thinking arrives within deadline.
+func withFirstTokenDeadline(deadline time.Duration) turnOption {
+	return func(t *Turn) {
+		t.firstTokenDeadline = deadline
+	}
+}
+
+// withNotice delivers an SDK-emitted event at the start of the first step.
+func withNotice(event wire.Event) turnOption {
+	return func(t *Turn) {
+		t.notices = append(t.notices, event)
+	}
 }
 
 func (t *Turn) watch(parent context.Context) {
@@ -73,17 +278,48 @@ func (t *Turn) watch(parent context.Context) {
 		return
 	case <-parent.Done():
 	}
+	if errors.Is(t.ctx.Err(), context.DeadlineExceeded) {
+		t.timeout()
+	}
 	t.tp.Cancel(&wire.CancelParams{})
 }
 
 func (t *Turn) traverse(incoming <-chan wire.Message, steps chan<- *Step) {
-	defer close(steps)
-	defer close(t.wireRequestResponseChan)
-	defer t.Cancel()
 	var (
-		outgoing chan wire.Message
-		turnEnd  bool
+		outgoing  chan wire.Message
+		turnBegin wire.TurnBegin
+		turnEnd   bool
+		// switching fires once Events is called; it is set to nil after the
+		// turn has switched to delivering messages as events.
+		switching  = (<-chan struct{})(t.switching)
+		eventMode  bool
+		coalesced  strings.Builder
+		coalescing bool
+		timer      *time.Timer
+		flushing   <-chan time.Time
+		firstToken <-chan time.Time
 	)
+	if t.firstTokenDeadline > 0 {
+		deadline := time.NewTimer(t.firstTokenDeadline)
+		defer deadline.Stop()
+		firstToken = deadline.C
+	}
+	defer func() {
+		if !eventMode {
+			close(steps)
+		}
+		close(t.events)
+	}()
+	defer close(t.wireRequestResponseChan)
+	defer func() {
+		t.Cancel()
+		// A turn cut short by its context reports why, rather than the
+		// error of the prompt call left behind.
+		if err := turnContextError(t.ctx); err != nil && !t.completed() {
+			t.errorPointer.Store(&err)
+		}
+		t.finish()
+	}()
 	defer func() {
 		if outgoing != nil {
 			close(outgoing)
@@ -92,45 +328,187 @@ func (t *Turn) traverse(incoming <-chan wire.Message, steps chan<- *Step) {
 			t.resultPointer.Store(&wire.PromptResult{Status: wire.PromptResultStatusUnexpectedEOF})
 		}
 	}()
+	emit := func(msg wire.Message) bool {
+		event, ok := msg.(wire.Event)
+		if !ok {
+			event = wire.PendingRequest{Request: msg.(wire.Request)}
+		}
+		select {
+		case t.events <- event:
+			return true
+		case <-t.current.Done():
+			return false
+		}
+	}
+	// switchToEvents ends step delivery and starts the event stream with the
+	// TurnBegin the consumer has not seen as an event yet.
+	switchToEvents := func() bool {
+		eventMode, switching = true, nil
+		if outgoing != nil {
+			close(outgoing)
+			outgoing = nil
+		}
+		close(steps)
+		return emit(turnBegin)
+	}
+	// checkSwitch switches to event mode if Events has been called, so that
+	// nothing goes to Steps once Events has returned, even while the consumer
+	// is still reading a step.
+	checkSwitch := func() bool {
+		select {
+		case <-switching:
+			return switchToEvents()
+		default:
+			return true
+		}
+	}
+	forward := func(msg wire.Message) bool {
+		if !checkSwitch() {
+			return false
+		}
+		if eventMode {
+			return emit(msg)
+		}
+		if outgoing == nil {
+			return true
+		}
+		select {
+		case outgoing <- msg:
+			return true
+		case <-switching:
+			return switchToEvents() && emit(msg)
+		case <-t.current.Done():
+			return false
+		}
+	}
+	notify := func() bool {
+		for _, notice := range t.notices {
+			if !forward(notice) {
+				return false
+			}
+		}
+		t.notices = nil
+		return true
+	}
+	flush := func() bool {
+		if !coalescing {
+			return true
+		}
+		coalescing, flushing = false, nil
+		timer.Stop()
+		part := wire.NewTextContentPart(coalesced.String())
+		coalesced.Reset()
+		return forward(part)
+	}
 	select {
 	case msg, ok := <-incoming:
 		if !ok {
 			return
 		}
-		if _, is := msg.(wire.TurnBegin); !is {
+		begin, is := msg.(wire.TurnBegin)
+		if !is {
 			t.errorPointer.Store(&ErrTurnNotFound)
 			return
 		}
+		turnBegin = begin
 	case <-t.current.Done():
 		return
 	}
-	for msg := range incoming {
+	for {
+		var msg wire.Message
+		select {
+		case m, ok := <-incoming:
+			if !ok {
+				flush()
+				return
+			}
+			msg = m
+		case <-flushing:
+			if !flush() {
+				return
+			}
+			continue
+		case <-switching:
+			if !switchToEvents() {
+				return
+			}
+			continue
+		case <-firstToken:
+			firstToken = nil
+			t.errorPointer.Store(&ErrFirstTokenTimeout)
+			t.timeout()
+			t.cancel()
+			continue
+		}
+		if unknown, ok := msg.(wire.UnknownEvent); ok && t.strictWire {
+			err := fmt.Errorf("%w: %q", ErrUnknownEvent, unknown.Type)
+			t.errorPointer.Store(&err)
+			t.cancel()
+			continue
+		}
+		if part, ok := msg.(wire.ContentPart); ok && (part.Type == wire.ContentPartTypeText || part.Type == wire.ContentPartTypeThink) {
+			firstToken = nil
+		}
+		if part, ok := msg.(wire.ContentPart); ok && part.Type == wire.ContentPartTypeText && t.coalesceWindow > 0 && (outgoing != nil || eventMode) {
+			coalesced.WriteString(part.Text.Value)
+			if !coalescing {
+				coalescing = true
+				if timer == nil {
+					timer = time.NewTimer(t.coalesceWindow)
+				} else {
+					timer.Reset(t.coalesceWindow)
+				}
+				flushing = timer.C
+			}
+			continue
+		}
+		if !flush() {
+			return
+		}
 		switch x := msg.(type) {
 		case wire.TurnEnd:
 			turnEnd = true
+			if !checkSwitch() {
+				return
+			}
+			if eventMode {
+				emit(x)
+			}
 			return
 		case wire.Request:
-			if outgoing != nil {
-				select {
-				case outgoing <- x:
-				case <-t.current.Done():
-					return
-				}
+			if !forward(x) {
+				return
 			}
 		case wire.Event:
 			switch x.EventType() {
 			case wire.EventTypeTurnBegin:
 				panic("wire.TurnBegin event should not be received")
 			case wire.EventTypeStepBegin:
+				if !checkSwitch() {
+					return
+				}
+				if eventMode {
+					if !emit(x) || !notify() {
+						return
+					}
+					continue
+				}
 				if outgoing != nil {
 					close(outgoing)
 				}
 				outgoing = make(chan wire.Message)
 				select {
 				case steps <- &Step{n: x.(wire.StepBegin).N, Messages: outgoing}:
+				case <-switching:
+					if !switchToEvents() || !emit(x) {
+						return
+					}
 				case <-t.current.Done():
 					return
 				}
+				if !notify() {
+					return
+				}
 			case wire.EventTypeStatusUpdate:
 				update := x.(wire.StatusUpdate)
 			CAS:
@@ -151,13 +529,22 @@ func (t *Turn) traverse(incoming <-chan wire.Message, steps chan<- *Step) {
 						break CAS
 					}
 				}
+				if t.contextPressure != nil {
+					t.contextPressure.report(update)
+				}
+				if !checkSwitch() || eventMode && !emit(x) {
+					return
+				}
+			case wire.EventTypeCompactionEnd:
+				for _, callback := range t.compactionCallbacks {
+					callback(x.(wire.CompactionEnd))
+				}
+				if !forward(x) {
+					return
+				}
 			default:
-				if outgoing != nil {
-					select {
-					case outgoing <- x:
-					case <-t.current.Done():
-						return
-					}
+				if !forward(x) {
+					return
 				}
 			}
 		default:
@@ -166,31 +553,210 @@ func (t *Turn) traverse(incoming <-chan wire.Message, steps chan<- *Step) {
 	}
 }
 
+// Events switches the turn to delivering every message as an event on the
+// returned channel, for consumers that want the raw event stream (TurnBegin,
+// StepBegin, StatusUpdate, StepInterrupted, CompactionBegin/End, TurnEnd, ...)
+// rather than messages grouped into steps. Requests that need a response,
+// such as ApprovalRequest, arrive wrapped in wire.PendingRequest, and events
+// of types the SDK does not know as wire.UnknownEvent.
+//
+// Once Events is called, Turn.Steps is closed (after closing the Messages of
+// the step in progress) and nothing more is delivered there. The stream
+// always starts with TurnBegin and does not repeat messages already delivered
+// on Steps; StatusUpdate events are still folded into Usage. The channel is
+// unbuffered, so a slow consumer holds back the turn, and it is closed once
+// the turn is done (after TurnEnd, or when the CLI goes away), at which point
+// Err and Result are final. Repeated calls return the same channel.
+func (t *Turn) Events() <-chan wire.Event {
+	t.switchOnce.Do(func() { close(t.switching) })
+	return t.events
+}
+
+// All returns an iterator over the turn's events, as delivered by Events,
+// for use with range:
+//
+//	for event, err := range turn.All(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Each event comes with a nil error. Once the turn is done, a final nil event
+// with Turn.Err is yielded if the turn failed. If ctx is done first, the turn
+// is cancelled and a final nil event with ctx's error is yielded. Breaking
+// out of the loop early cancels the turn as well, so nothing is left waiting
+// for a consumer. The iterator is single-use, and the same rules as for
+// Events apply.
+func (t *Turn) All(ctx context.Context) iter.Seq2[wire.Event, error] {
+	return func(yield func(wire.Event, error) bool) {
+		events := t.Events()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					if err := t.Err(); err != nil {
+						yield(nil, err)
+					}
+					return
+				}
+				if !yield(event, nil) {
+					t.Cancel() //nolint:errcheck
+					return
+				}
+			case <-ctx.Done():
+				t.Cancel() //nolint:errcheck
+				yield(nil, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
 func (t *Turn) ID() uint64 {
 	return t.id
 }
 
+// Metadata returns a copy of the metadata given with WithTurnMetadata, or nil
+// if there was none.
+func (t *Turn) Metadata() map[string]string {
+	return maps.Clone(t.metadata)
+}
+
+// ExternalID returns the ID given with WithTurnID, or "" if there was none.
+func (t *Turn) ExternalID() string {
+	return t.externalID
+}
+
+// Err returns the error that ended the turn, such as a failed prompt request,
+// or nil if it completed normally. If the context passed to Session.Prompt is
+// done before the turn completes, Err returns the context's error, or
+// ErrTurnTimeout if WithTurnTimeout ended it. The value is only final once
+// the turn is done, i.e. once Turn.Steps, or Turn.Events in event mode, has
+// been closed, which happens after everything delivered there has been
+// consumed. Before that Err may still return nil for a turn that is about to
+// fail. Once final, Err always returns the same value and is safe to call
+// repeatedly and concurrently.
 func (t *Turn) Err() error {
-	if err := t.errorPointer.Load(); err != nil && *err != nil {
+	err := t.errorPointer.Load()
+	if err == nil || *err == nil {
+		return nil
+	}
+	if len(t.metadata) == 0 {
 		return *err
 	}
-	return nil
+	// Wrap each error once, so that repeated calls return the same value.
+	wrapped := t.metadataErr.Load()
+	if wrapped == nil || wrapped.src != err {
+		t.metadataErr.CompareAndSwap(wrapped, &metadataErr{src: err, err: &MetadataError{Metadata: t.metadata, err: *err}})
+		wrapped = t.metadataErr.Load()
+	}
+	return wrapped.err
+}
+
+// metadataErr is the MetadataError Turn.Err returns for the error src points
+// to.
+type metadataErr struct {
+	src *error
+	err error
+}
+
+// completed reports whether the CLI ran the turn to its end.
+func (t *Turn) completed() bool {
+	switch t.Result().Status {
+	case wire.PromptResultStatusFinished, wire.PromptResultStatusMaxStepsReached:
+		return true
+	}
+	return false
 }
 
 func (t *Turn) Result() wire.PromptResult {
 	return *t.resultPointer.Load()
 }
 
+// Usage returns the context and token usage reported by the CLI's
+// StatusUpdate events so far. Token counts are summed over the turn and are
+// final once Turn.Steps, or Turn.Events in event mode, has been closed. The
+// CLI does not report costs.
 func (t *Turn) Usage() *Usage {
 	return t.usage.Load()
 }
 
+// Text consumes Turn.Steps and returns the text of the assistant's reply,
+// concatenated from the turn's text content parts, once the turn is done,
+// together with Turn.Err. Thinking, tool calls and status updates are skipped,
+// and approval requests that reach the turn are rejected; approve them with
+// WithApprovalHandler or WithAutoApprove instead. If ctx is done first, the
+// turn is cancelled and Text returns the text received so far and ctx's
+// error. Text must not be combined with reading Turn.Steps or Turn.Events.
+func (t *Turn) Text(ctx context.Context) (string, error) {
+	cancelled := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(cancelled)
+		t.Cancel()
+	})
+	defer func() {
+		if !stop() {
+			<-cancelled
+		}
+	}()
+	var text strings.Builder
+	for step := range t.Steps {
+		for msg := range step.Messages {
+			switch x := msg.(type) {
+			case wire.ContentPart:
+				if x.Type == wire.ContentPartTypeText {
+					text.WriteString(x.Text.Value)
+				}
+			case wire.ApprovalRequest:
+				x.Respond(wire.ApprovalRequestResponseReject)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil && !t.completed() {
+		return text.String(), err
+	}
+	return text.String(), t.Err()
+}
+
 func (t *Turn) Cancel() error {
 	t.cancel()
 	<-t.current.Done()
 	return t.exit(nil)
 }
 
+// turnContextError returns the error of the turn's context ctx, or
+// ErrTurnTimeout if it expired because of WithTurnTimeout.
+func turnContextError(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) && errors.Is(context.Cause(ctx), ErrTurnTimeout) {
+		return ErrTurnTimeout
+	}
+	return err
+}
+
+// timeout runs the timeout hooks for the first timeout that fires.
+func (t *Turn) timeout() {
+	if !t.timedOut.CompareAndSwap(false, true) {
+		return
+	}
+	for _, hook := range t.timeoutHooks {
+		hook(t)
+	}
+}
+
+// finish runs the turn hooks exactly once. It is only called by traverse on
+// its way out, after the turn's result and error are final, so hooks never
+// observe a value that is overwritten later. A hook may call Cancel.
+func (t *Turn) finish() {
+	if !t.finished.CompareAndSwap(false, true) {
+		return
+	}
+	for _, hook := range t.hooks {
+		hook(t)
+	}
+}
+
 type Step struct {
 	n        int
 	Messages <-chan wire.Message
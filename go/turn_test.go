@@ -2,6 +2,11 @@ package kimi
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -338,6 +343,277 @@ func TestTurn_watch_ContextCancel(t *testing.T) {
 	ctrl.Finish()
 }
 
+func TestTurn_Err_ContextDone(t *testing.T) {
+	tests := []struct {
+		name   string
+		status wire.PromptResultStatus
+		want   error
+	}{
+		{"interrupted", wire.PromptResultStatusCancelled, context.DeadlineExceeded},
+		{"finished", wire.PromptResultStatusFinished, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: tt.status})
+
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			turn := turnBegin(ctx, 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit)
+			msgs <- wire.TurnBegin{}
+			<-ctx.Done()
+			close(msgs)
+			for range turn.Steps {
+			}
+
+			if err := turn.Err(); err != tt.want {
+				t.Errorf("expected Err() = %v, got %v", tt.want, err)
+			}
+			ctrl.Finish()
+		})
+	}
+}
+
+func TestTurn_Text(t *testing.T) {
+	turn, _, msgs, _, closeMsgs, cleanup := setupTurnWithVersion(t, "1.1")
+	defer cleanup()
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("Hello, ")
+	msgs <- wire.ContentPart{Type: wire.ContentPartTypeThink, Think: wire.Optional[string]{Value: "greet", Valid: true}}
+	msgs <- wire.StatusUpdate{}
+	msgs <- wire.NewTextContentPart("world")
+	msgs <- wire.StepBegin{N: 2}
+	msgs <- wire.NewTextContentPart("!")
+	msgs <- wire.TurnEnd{}
+	closeMsgs()
+
+	text, err := turn.Text(context.Background())
+	if err != nil {
+		t.Fatalf("Text: %v", err)
+	}
+	if text != "Hello, world!" {
+		t.Errorf("expected %q, got %q", "Hello, world!", text)
+	}
+}
+
+func TestTurn_Text_ContextDone(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockTP := transport.NewMockTransport(ctrl)
+	msgs := make(chan wire.Message, 10)
+	var once sync.Once
+	// The CLI ends the prompt once it is cancelled.
+	mockTP.EXPECT().Cancel(gomock.Any()).DoAndReturn(func(*wire.CancelParams) (*wire.CancelResult, error) {
+		once.Do(func() { close(msgs) })
+		return &wire.CancelResult{}, nil
+	}).AnyTimes()
+
+	result := new(atomic.Pointer[wire.PromptResult])
+	usrc := make(chan wire.RequestResponse, 1)
+	exit := func(err error) error { return err }
+	turn := turnBegin(context.Background(), 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit)
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("partial")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	text, err := turn.Text(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if text != "partial" {
+		t.Errorf("expected the partial text, got %q", text)
+	}
+}
+
+func TestTurn_FirstTokenDeadline(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []wire.Message
+		want   error
+	}{
+		{"no token", []wire.Message{wire.StepBegin{N: 1}, wire.StatusUpdate{}}, ErrFirstTokenTimeout},
+		{"thinking", []wire.Message{wire.StepBegin{N: 1}, wire.ContentPart{Type: wire.ContentPartTypeThink}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			cancelled := make(chan struct{})
+			var once sync.Once
+			mockTP.EXPECT().Cancel(gomock.Any()).DoAndReturn(func(*wire.CancelParams) (*wire.CancelResult, error) {
+				once.Do(func() { close(cancelled) })
+				return &wire.CancelResult{}, nil
+			}).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			turn := turnBegin(context.Background(), 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit,
+				withFirstTokenDeadline(50*time.Millisecond))
+			msgs <- wire.TurnBegin{}
+			for _, msg := range tt.events {
+				msgs <- msg
+			}
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				for step := range turn.Steps {
+					for range step.Messages {
+					}
+				}
+			}()
+
+			select {
+			case <-cancelled:
+				if tt.want == nil {
+					t.Error("turn cancelled despite the first token")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.want != nil {
+					t.Error("turn not cancelled after the first token deadline")
+				}
+			}
+			close(msgs)
+			<-drained
+			if err := turn.Err(); err != tt.want {
+				t.Errorf("expected Err() = %v, got %v", tt.want, err)
+			}
+			ctrl.Finish()
+		})
+	}
+}
+
+func TestTurn_TurnTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		cause  error
+		events []wire.Message
+		want   error
+	}{
+		{"expires", ErrTurnTimeout, []wire.Message{wire.TurnBegin{}, wire.StepBegin{N: 1}}, ErrTurnTimeout},
+		{"expires before TurnBegin", ErrTurnTimeout, nil, ErrTurnTimeout},
+		{"context deadline", nil, []wire.Message{wire.TurnBegin{}, wire.StepBegin{N: 1}}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			ctx, cancel := context.WithTimeoutCause(context.Background(), 50*time.Millisecond, tt.cause)
+			defer cancel()
+			hooked := make(chan struct{})
+			turn := turnBegin(ctx, 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit,
+				withTimeoutHook(func(*Turn) { close(hooked) }))
+			for _, msg := range tt.events {
+				msgs <- msg
+			}
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				for step := range turn.Steps {
+					for range step.Messages {
+					}
+				}
+			}()
+
+			select {
+			case <-hooked:
+			case <-time.After(time.Second):
+				t.Fatal("timeout hook not called")
+			}
+			close(msgs)
+			<-drained
+			if err := turn.Err(); err != tt.want {
+				t.Errorf("expected Err() = %v, got %v", tt.want, err)
+			}
+			ctrl.Finish()
+		})
+	}
+}
+
+func TestTurn_TimeoutHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		options []turnOption
+		want    error
+	}{
+		{"first token", time.Hour, []turnOption{withFirstTokenDeadline(20 * time.Millisecond)}, ErrFirstTokenTimeout},
+		{"context deadline", 20 * time.Millisecond, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			var calls atomic.Int32
+			errs := make(chan error, 2)
+			options := append(tt.options, withTimeoutHook(func(turn *Turn) {
+				calls.Add(1)
+				errs <- turn.Err()
+			}))
+
+			turn := turnBegin(ctx, 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit, options...)
+			msgs <- wire.TurnBegin{}
+			msgs <- wire.StepBegin{N: 1}
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				for step := range turn.Steps {
+					for range step.Messages {
+					}
+				}
+			}()
+			select {
+			case err := <-errs:
+				if err != tt.want {
+					t.Errorf("expected Err() = %v in the hook, got %v", tt.want, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout hook not called")
+			}
+			close(msgs)
+			<-drained
+			if n := calls.Load(); n != 1 {
+				t.Errorf("expected the hook to run once, ran %d times", n)
+			}
+			ctrl.Finish()
+		})
+	}
+}
+
 func TestTurn_traverse_TurnEnd(t *testing.T) {
 	turn, _, msgs, cancel, _, cleanup := setupTurnWithVersion(t, "1.2")
 	defer cleanup()
@@ -467,3 +743,558 @@ func TestTurn_traverse_NoUnexpectedEOF_WireVersion11(t *testing.T) {
 		t.Error("expected status to NOT be UnexpectedEOF for wire version < 1.2")
 	}
 }
+
+func TestTurn_ResultHook(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockTP := transport.NewMockTransport(ctrl)
+	mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+	result := new(atomic.Pointer[wire.PromptResult])
+	result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+
+	msgs := make(chan wire.Message, 10)
+	usrc := make(chan wire.RequestResponse, 1)
+	exit := func(err error) error { return err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int64
+	var hooked *Turn
+	hook := withTurnHook(func(turn *Turn) {
+		calls.Add(1)
+		hooked = turn
+		// Re-entrant Cancel from a hook must not deadlock or re-run hooks.
+		turn.Cancel()
+	})
+	turn := turnBegin(ctx, 0, mockTP, new(atomic.Pointer[error]), result, "1.2", msgs, usrc, exit, hook)
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.TurnEnd{}
+
+	for step := range turn.Steps {
+		for range step.Messages {
+		}
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected hook to run once, got %d", n)
+	}
+	if hooked != turn {
+		t.Fatal("expected hook to receive the completed turn")
+	}
+
+	turn.Cancel()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected hook to run once after repeated Cancel, got %d", n)
+	}
+
+	close(msgs)
+	time.Sleep(50 * time.Millisecond)
+	ctrl.Finish()
+}
+
+func TestTurn_traverse_CoalesceTextDeltas(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockTP := transport.NewMockTransport(ctrl)
+	mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+	result := new(atomic.Pointer[wire.PromptResult])
+	result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+
+	msgs := make(chan wire.Message, 10)
+	usrc := make(chan wire.RequestResponse, 1)
+	exit := func(err error) error { return err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	turn := turnBegin(ctx, 0, mockTP, new(atomic.Pointer[error]), result, "1.2", msgs, usrc, exit,
+		withCoalesceWindow(time.Hour))
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("Hel")
+	msgs <- wire.NewTextContentPart("lo")
+	msgs <- wire.ContentPart{Type: wire.ContentPartTypeThink, Think: wire.Optional[string]{Value: "hmm", Valid: true}}
+	msgs <- wire.NewTextContentPart(", ")
+	msgs <- wire.NewTextContentPart("world")
+	msgs <- wire.TurnEnd{}
+
+	var got []wire.ContentPart
+	for step := range turn.Steps {
+		for msg := range step.Messages {
+			got = append(got, msg.(wire.ContentPart))
+		}
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("expected 3 messages, got %d: %+v", len(got), got)
+	}
+	if got[0].Text.Value != "Hello" {
+		t.Errorf("expected first delta 'Hello', got %q", got[0].Text.Value)
+	}
+	if got[1].Type != wire.ContentPartTypeThink {
+		t.Errorf("expected think part to pass through, got %s", got[1].Type)
+	}
+	if got[2].Text.Value != ", world" {
+		t.Errorf("expected trailing delta flushed before TurnEnd, got %q", got[2].Text.Value)
+	}
+
+	close(msgs)
+	time.Sleep(50 * time.Millisecond)
+	ctrl.Finish()
+}
+
+func TestTurn_traverse_CoalesceTextDeltas_Window(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockTP := transport.NewMockTransport(ctrl)
+	mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+	result := new(atomic.Pointer[wire.PromptResult])
+	result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+
+	msgs := make(chan wire.Message, 10)
+	usrc := make(chan wire.RequestResponse, 1)
+	exit := func(err error) error { return err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	turn := turnBegin(ctx, 0, mockTP, new(atomic.Pointer[error]), result, "1.2", msgs, usrc, exit,
+		withCoalesceWindow(20*time.Millisecond))
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("a")
+	msgs <- wire.NewTextContentPart("b")
+
+	step := <-turn.Steps
+	select {
+	case msg := <-step.Messages:
+		if cp := msg.(wire.ContentPart); cp.Text.Value != "ab" {
+			t.Errorf("expected coalesced delta 'ab', got %q", cp.Text.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for coalesced delta to be flushed by the window")
+	}
+
+	msgs <- wire.TurnEnd{}
+	for range step.Messages {
+	}
+	for range turn.Steps {
+	}
+
+	close(msgs)
+	time.Sleep(50 * time.Millisecond)
+	ctrl.Finish()
+}
+
+func TestTurn_Events(t *testing.T) {
+	turn, _, msgs, _, closeMsgs, cleanup := setupTurnWithVersion(t, "1.2")
+	defer cleanup()
+
+	events := turn.Events()
+	if turn.Events() != events {
+		t.Fatal("expected repeated Events calls to return the same channel")
+	}
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("hi")
+	msgs <- wire.StatusUpdate{TokenUsage: wire.Optional[wire.TokenUsage]{Value: wire.TokenUsage{Output: 3}, Valid: true}}
+	msgs <- wire.ApprovalRequest{ID: "approval-1"}
+	msgs <- wire.TurnEnd{}
+	closeMsgs()
+
+	var got []wire.EventType
+	for event := range events {
+		got = append(got, event.EventType())
+		if pending, ok := event.(wire.PendingRequest); ok {
+			if req, ok := pending.Request.(wire.ApprovalRequest); !ok || req.ID != "approval-1" {
+				t.Errorf("expected pending ApprovalRequest approval-1, got %+v", pending.Request)
+			}
+		}
+	}
+	want := []wire.EventType{
+		wire.EventTypeTurnBegin,
+		wire.EventTypeStepBegin,
+		wire.EventTypeContentPart,
+		wire.EventTypeStatusUpdate,
+		wire.EventTypePendingRequest,
+		wire.EventTypeTurnEnd,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+	if _, ok := <-turn.Steps; ok {
+		t.Error("expected Steps to be closed in event mode")
+	}
+	if usage := turn.Usage(); usage.Tokens.Output != 3 {
+		t.Errorf("expected StatusUpdate to be folded into usage, got %+v", usage.Tokens)
+	}
+	if status := turn.Result().Status; status == wire.PromptResultStatusUnexpectedEOF {
+		t.Error("expected TurnEnd to be recorded in event mode")
+	}
+}
+
+func TestTurn_Events_AfterSteps(t *testing.T) {
+	turn, _, msgs, _, closeMsgs, cleanup := setupTurnWithVersion(t, "1.2")
+	defer cleanup()
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("first")
+
+	step := <-turn.Steps
+	if msg := <-step.Messages; msg.(wire.ContentPart).Text.Value != "first" {
+		t.Fatalf("unexpected first message %+v", msg)
+	}
+
+	events := turn.Events()
+	msgs <- wire.NewTextContentPart("second")
+	msgs <- wire.TurnEnd{}
+	closeMsgs()
+
+	for range step.Messages {
+		t.Error("expected no more messages on the step after switching")
+	}
+	for range turn.Steps {
+		t.Error("expected no more steps after switching")
+	}
+	var got []wire.Event
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected TurnBegin, ContentPart and TurnEnd, got %+v", got)
+	}
+	if _, ok := got[0].(wire.TurnBegin); !ok {
+		t.Errorf("expected stream to start with TurnBegin, got %T", got[0])
+	}
+	if part, ok := got[1].(wire.ContentPart); !ok || part.Text.Value != "second" {
+		t.Errorf("expected only undelivered content, got %+v", got[1])
+	}
+}
+
+func TestTurn_CompactionCallbacks(t *testing.T) {
+	for _, eventMode := range []bool{false, true} {
+		t.Run(fmt.Sprintf("eventMode=%v", eventMode), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			var calls []string
+			callbacks := []func(wire.CompactionEnd){
+				func(wire.CompactionEnd) { calls = append(calls, "first") },
+				func(wire.CompactionEnd) { calls = append(calls, "second") },
+			}
+			turn := turnBegin(context.Background(), 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit,
+				withCompactionCallbacks(callbacks))
+			for _, msg := range []wire.Message{
+				wire.TurnBegin{}, wire.StepBegin{N: 1}, wire.CompactionBegin{}, wire.CompactionEnd{}, wire.TurnEnd{},
+			} {
+				msgs <- msg
+			}
+			close(msgs)
+
+			var delivered []wire.Message
+			if eventMode {
+				for event := range turn.Events() {
+					delivered = append(delivered, event)
+				}
+			} else {
+				for step := range turn.Steps {
+					for msg := range step.Messages {
+						delivered = append(delivered, msg)
+					}
+				}
+			}
+			if !slices.Equal(calls, []string{"first", "second"}) {
+				t.Errorf("expected both callbacks to run once in order, got %v", calls)
+			}
+			if !slices.Contains(delivered, wire.Message(wire.CompactionEnd{})) {
+				t.Errorf("expected CompactionEnd to still be delivered, got %v", delivered)
+			}
+			ctrl.Finish()
+		})
+	}
+}
+
+func TestTurn_Metadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockTP := transport.NewMockTransport(ctrl)
+	mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+	result := new(atomic.Pointer[wire.PromptResult])
+	result.Store(&wire.PromptResult{Status: wire.PromptResultStatusFinished})
+	errorPointer := new(atomic.Pointer[error])
+	promptErr := errors.New("prompt failed")
+	errorPointer.Store(&promptErr)
+
+	msgs := make(chan wire.Message, 10)
+	usrc := make(chan wire.RequestResponse, 1)
+	exit := func(err error) error { return err }
+
+	metadata := map[string]string{"tenant": "acme", "request": "r-1"}
+	turn := turnBegin(context.Background(), 0, mockTP, errorPointer, result, "1.1", msgs, usrc, exit, withMetadata(metadata))
+	close(msgs)
+	for range turn.Steps {
+	}
+
+	got := turn.Metadata()
+	if !maps.Equal(got, metadata) {
+		t.Fatalf("expected metadata %v, got %v", metadata, got)
+	}
+	got["tenant"] = "mutated"
+	if turn.Metadata()["tenant"] != "acme" {
+		t.Error("expected Metadata to return a copy")
+	}
+
+	err := turn.Err()
+	if !errors.Is(err, promptErr) {
+		t.Fatalf("expected Err to wrap the turn's error, got %v", err)
+	}
+	var metaErr *MetadataError
+	if !errors.As(err, &metaErr) || !maps.Equal(metaErr.Metadata, metadata) {
+		t.Fatalf("expected a MetadataError with the metadata, got %v", err)
+	}
+	if want := "prompt failed (turn metadata: request=r-1, tenant=acme)"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+	if turn.Err() != err {
+		t.Error("expected repeated calls to return the same error")
+	}
+}
+
+func TestWithTurnMetadata(t *testing.T) {
+	opt := &promptOption{}
+	WithTurnMetadata(map[string]string{"tenant": "acme", "request": "r-1"})(opt)
+	WithTurnMetadata(map[string]string{"request": "r-2"})(opt)
+	WithTurnMetadata(nil)(opt)
+
+	want := map[string]string{"tenant": "acme", "request": "r-2"}
+	if !maps.Equal(opt.metadata, want) {
+		t.Fatalf("expected metadata %v, got %v", want, opt.metadata)
+	}
+}
+
+func TestTurn_All(t *testing.T) {
+	turn, _, msgs, _, closeMsgs, cleanup := setupTurnWithVersion(t, "1.2")
+	defer cleanup()
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("hi")
+	msgs <- wire.TurnEnd{}
+	closeMsgs()
+
+	var got []wire.EventType
+	for event, err := range turn.All(context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, event.EventType())
+	}
+	want := []wire.EventType{
+		wire.EventTypeTurnBegin,
+		wire.EventTypeStepBegin,
+		wire.EventTypeContentPart,
+		wire.EventTypeTurnEnd,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+}
+
+func TestTurn_All_Err(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockTP := transport.NewMockTransport(ctrl)
+	mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+	result := new(atomic.Pointer[wire.PromptResult])
+	result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+	errorPointer := new(atomic.Pointer[error])
+	promptErr := errors.New("prompt failed")
+	errorPointer.Store(&promptErr)
+	msgs := make(chan wire.Message, 10)
+	usrc := make(chan wire.RequestResponse, 1)
+	exit := func(err error) error { return err }
+
+	turn := turnBegin(context.Background(), 0, mockTP, errorPointer, result, "1.1", msgs, usrc, exit)
+	msgs <- wire.TurnBegin{}
+	close(msgs)
+
+	var last error
+	for event, err := range turn.All(context.Background()) {
+		if last != nil {
+			t.Fatal("expected the error to be yielded last")
+		}
+		if err == nil && event == nil {
+			t.Fatal("expected a nil error to come with an event")
+		}
+		last = err
+	}
+	if !errors.Is(last, promptErr) {
+		t.Fatalf("expected the turn's error, got %v", last)
+	}
+}
+
+func TestTurn_All_Break(t *testing.T) {
+	turn, _, msgs, _, _, cleanup := setupTurnWithVersion(t, "1.2")
+	defer cleanup()
+
+	msgs <- wire.TurnBegin{}
+	msgs <- wire.StepBegin{N: 1}
+	msgs <- wire.NewTextContentPart("hi")
+
+	for range turn.All(context.Background()) {
+		break
+	}
+	select {
+	case _, ok := <-turn.Events():
+		if ok {
+			t.Fatal("expected no events after breaking out of the loop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected breaking out of the loop to end the turn")
+	}
+}
+
+func TestTurn_All_ContextDone(t *testing.T) {
+	turn, _, msgs, _, _, cleanup := setupTurnWithVersion(t, "1.2")
+	defer cleanup()
+
+	msgs <- wire.TurnBegin{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last error
+	for event, err := range turn.All(ctx) {
+		if err != nil {
+			last = err
+			continue
+		}
+		if event.EventType() == wire.EventTypeTurnBegin {
+			cancel()
+		}
+	}
+	if !errors.Is(last, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", last)
+	}
+	select {
+	case <-turn.current.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected a done context to cancel the turn")
+	}
+}
+
+func TestTurn_UnknownEvent(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			var options []turnOption
+			if strict {
+				options = append(options, withStrictWire())
+			}
+			turn := turnBegin(context.Background(), 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit, options...)
+			events := turn.Events()
+			msgs <- wire.TurnBegin{}
+			msgs <- wire.UnknownEvent{Type: "FutureEvent", Raw: []byte(`{}`)}
+			msgs <- wire.TurnEnd{}
+			close(msgs)
+
+			var got []wire.EventType
+			for event := range events {
+				got = append(got, event.EventType())
+			}
+			if strict {
+				if !errors.Is(turn.Err(), ErrUnknownEvent) {
+					t.Fatalf("expected ErrUnknownEvent, got %v", turn.Err())
+				}
+				if slices.Contains(got, "FutureEvent") {
+					t.Errorf("expected the unknown event not to be delivered, got %v", got)
+				}
+				return
+			}
+			if turn.Err() != nil {
+				t.Fatalf("unexpected error: %v", turn.Err())
+			}
+			want := []wire.EventType{wire.EventTypeTurnBegin, "FutureEvent", wire.EventTypeTurnEnd}
+			if !slices.Equal(got, want) {
+				t.Fatalf("expected events %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestTurn_ContextPressure(t *testing.T) {
+	status := func(fraction float64, tokens int) wire.StatusUpdate {
+		return wire.StatusUpdate{
+			ContextUsage: wire.Optional[float64]{Value: fraction, Valid: true},
+			TokenUsage:   wire.Optional[wire.TokenUsage]{Value: wire.TokenUsage{InputOther: tokens}, Valid: tokens > 0},
+		}
+	}
+	for _, tt := range []struct {
+		name string
+		max  int
+		want [][2]int
+	}{
+		{"configured", 1000, [][2]int{{850, 1000}, {900, 1000}}},
+		{"estimated", 0, [][2]int{{850, 1000}, {0, 0}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockTP := transport.NewMockTransport(ctrl)
+			mockTP.EXPECT().Cancel(gomock.Any()).Return(&wire.CancelResult{}, nil).AnyTimes()
+
+			result := new(atomic.Pointer[wire.PromptResult])
+			result.Store(&wire.PromptResult{Status: wire.PromptResultStatusPending})
+			msgs := make(chan wire.Message, 10)
+			usrc := make(chan wire.RequestResponse, 1)
+			exit := func(err error) error { return err }
+
+			var got [][2]int
+			pressure := newContextPressure([]func(used, max int){func(used, max int) {
+				got = append(got, [2]int{used, max})
+			}}, tt.max)
+			turn := turnBegin(context.Background(), 0, mockTP, new(atomic.Pointer[error]), result, "1.1", msgs, usrc, exit, withContextPressure(pressure))
+			msgs <- wire.TurnBegin{}
+			msgs <- status(0.5, 500)
+			msgs <- status(0.85, 850)
+			// Still above the threshold: no new warning.
+			msgs <- status(0.95, 950)
+			// Compacted, then above the threshold again.
+			msgs <- status(0.3, 300)
+			msgs <- status(0.9, 0)
+			msgs <- wire.TurnEnd{}
+			close(msgs)
+			for step := range turn.Steps {
+				for range step.Messages {
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected callbacks %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
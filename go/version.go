@@ -0,0 +1,61 @@
+package kimi
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrIncompatibleCLI = errors.New("incompatible kimi CLI")
+)
+
+// The range of wire protocol versions this SDK speaks, as [min, max).
+const (
+	minWireProtocolVersion = "1"
+	maxWireProtocolVersion = "2"
+)
+
+// cliInfo is the output of `kimi info --json`.
+type cliInfo struct {
+	CLIVersion          string `json:"kimi_cli_version"`
+	WireProtocolVersion string `json:"wire_protocol_version"`
+}
+
+// checkCompatible returns an error wrapping ErrIncompatibleCLI if the CLI does
+// not speak a wire protocol version this SDK supports.
+func (info *cliInfo) checkCompatible() error {
+	cli := cmp.Or(info.CLIVersion, "unknown")
+	if info.WireProtocolVersion == "" {
+		return fmt.Errorf("%w: CLI %s does not report its wire protocol version, this SDK supports [%s, %s)",
+			ErrIncompatibleCLI, cli, minWireProtocolVersion, maxWireProtocolVersion)
+	}
+	if compareVersions(info.WireProtocolVersion, minWireProtocolVersion) < 0 ||
+		compareVersions(info.WireProtocolVersion, maxWireProtocolVersion) >= 0 {
+		return fmt.Errorf("%w: CLI %s speaks wire protocol %s, this SDK supports [%s, %s)",
+			ErrIncompatibleCLI, cli, info.WireProtocolVersion, minWireProtocolVersion, maxWireProtocolVersion)
+	}
+	return nil
+}
+
+// compareVersions compares dotted version numbers such as 1.2 and 1.10
+// component by component, treating missing components as 0. Components that
+// are not numbers compare as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package kimi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2", "1.2", 0},
+		{"1", "1.0", 0},
+		{"1.2", "1.10", -1},
+		{"2", "1.99", 1},
+		{"0.9", "1", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCLIInfo_CheckCompatible(t *testing.T) {
+	tests := []struct {
+		info cliInfo
+		want string
+	}{
+		{cliInfo{CLIVersion: "0.82", WireProtocolVersion: "1.1"}, ""},
+		{cliInfo{CLIVersion: "1.3", WireProtocolVersion: "1.10"}, ""},
+		{cliInfo{CLIVersion: "0.40", WireProtocolVersion: "0.9"}, "CLI 0.40 speaks wire protocol 0.9, this SDK supports [1, 2)"},
+		{cliInfo{CLIVersion: "2.0", WireProtocolVersion: "2.0"}, "CLI 2.0 speaks wire protocol 2.0, this SDK supports [1, 2)"},
+		{cliInfo{}, "CLI unknown does not report its wire protocol version"},
+	}
+	for _, tt := range tests {
+		err := tt.info.checkCompatible()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error %v", tt.info, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrIncompatibleCLI) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected ErrIncompatibleCLI containing %q, got %v", tt.info, tt.want, err)
+		}
+	}
+}
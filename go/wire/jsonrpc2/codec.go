@@ -21,6 +21,7 @@ func NewCodec(rwc io.ReadWriteCloser, options ...CodecOption) *Codec {
 	codec := &Codec{
 		donectx:        donectx,
 		cancel:         cancel,
+		rxfailed:       make(chan struct{}),
 		rwc:            rwc,
 		enc:            json.NewEncoder(rwc),
 		dec:            json.NewDecoder(rwc),
@@ -88,9 +89,10 @@ type Codec struct {
 
 	// --- Lifecycle control ---
 	// Context and wait group for managing goroutine lifecycle.
-	donectx context.Context    // Cancellation context to signal all goroutines to exit.
-	cancel  context.CancelFunc // Cancel function for donectx.
-	wg      sync.WaitGroup     // Tracks send() and recv() goroutines.
+	donectx  context.Context    // Cancellation context to signal all goroutines to exit.
+	cancel   context.CancelFunc // Cancel function for donectx.
+	wg       sync.WaitGroup     // Tracks send() and recv() goroutines.
+	rxfailed chan struct{}      // Closed once reading fails; pending requests can no longer complete.
 
 	// --- Underlying I/O ---
 	// Low-level I/O components for reading and writing JSON-RPC messages.
@@ -367,6 +369,7 @@ func (c *Codec) recv() {
 		if err := c.dec.Decode(&payload); err != nil {
 			c.cancel()
 			c.err.CompareAndSwap(nil, &wraperror{err})
+			close(c.rxfailed)
 			return
 		}
 		if payload != nil {
@@ -670,12 +673,17 @@ gracefulshutdown:
 		select {
 		case <-timer.C:
 			break gracefulshutdown
+		case <-c.rxfailed:
+			break gracefulshutdown
 		default:
 			pending := c.PendingRequests()
 			if pending == 0 {
 				break gracefulshutdown
 			}
-			time.Sleep(time.Duration(pending) * time.Second)
+			select {
+			case <-time.After(time.Duration(pending) * time.Second):
+			case <-c.rxfailed:
+			}
 		}
 	}
 	c.rxcloseonce.Do(func() {
@@ -269,6 +269,26 @@ func TestCodec_EOF_RemoteClose_ReadRequestHeaderReturnsEOF(t *testing.T) {
 	}
 }
 
+func TestCodec_Close_RemoteGone_DoesNotWaitForPendingRequests(t *testing.T) {
+	c1, c2 := net.Pipe()
+	codec := newTestCodec(c1, ShutdownTimeout(10*time.Second))
+	go io.Copy(io.Discard, c2) //nolint:errcheck
+
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "Transport.Prompt", Seq: 1}, &TestArgs{UserInput: "x"}); err != nil {
+		t.Fatalf("WriteRequest: %v", err)
+	}
+	if codec.PendingClientRequests() != 1 {
+		t.Fatalf("expected 1 pending client request, got %d", codec.PendingClientRequests())
+	}
+	_ = c2.Close()
+
+	start := time.Now()
+	_ = codec.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Close waited %v for a request the closed peer can never answer", elapsed)
+	}
+}
+
 func TestCodec_ReadResponseHeader_KnownID_CleansMaps(t *testing.T) {
 	c1, c2 := net.Pipe()
 	codec := newTestCodec(c1)
@@ -1357,3 +1377,57 @@ func TestCodec_StreamReceiver_Close_Idempotent(t *testing.T) {
 		return !exists
 	})
 }
+
+func TestCodec_PartialFrames_SplitAcrossReads(t *testing.T) {
+	large := strings.Repeat("résumé 日本語 ", 1<<10)
+	frames := ""
+	for _, id := range []string{"r1", "r2"} {
+		body, _ := json.Marshal(map[string]any{"echo": large + id})
+		frames += `{"jsonrpc":"2.0","id":"` + id + `","result":` + string(body) + "}\n"
+	}
+
+	for _, chunk := range []int{1, 7, 4093} {
+		t.Run(strconv.Itoa(chunk), func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			codec := newTestCodec(c1)
+			defer codec.Close()
+			defer c2.Close()
+
+			codec.clilock.Lock()
+			for seq, id := range []string{"r1", "r2"} {
+				codec.reqmeth[id] = "Transport.Prompt"
+				codec.clireqids[id] = uint64(seq + 1)
+			}
+			codec.clilock.Unlock()
+
+			// Each write is a separate read on the other end, so frames and
+			// multi-byte runes are split at arbitrary offsets.
+			go func() {
+				for data := frames; len(data) > 0; {
+					n := min(chunk, len(data))
+					if _, err := io.WriteString(c2, data[:n]); err != nil {
+						return
+					}
+					data = data[n:]
+				}
+			}()
+
+			for seq, id := range []string{"r1", "r2"} {
+				var r rpc.Response
+				if err := codec.ReadResponseHeader(&r); err != nil {
+					t.Fatalf("ReadResponseHeader: %v", err)
+				}
+				if r.Seq != uint64(seq+1) {
+					t.Fatalf("expected Seq %d, got %d", seq+1, r.Seq)
+				}
+				var reply TestReply
+				if err := codec.ReadResponseBody(&reply); err != nil {
+					t.Fatalf("ReadResponseBody: %v", err)
+				}
+				if reply.Echo != large+id {
+					t.Fatalf("frame %s corrupted: got %d bytes", id, len(reply.Echo))
+				}
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package wire
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 )
 
 type (
@@ -81,6 +84,10 @@ func (ApprovalRequestResolved) message() {}
 func (ApprovalResponse) message()        {}
 func (ApprovalRequest) message()         {}
 func (ToolCallRequest) message()         {}
+func (ToolCacheHit) message()            {}
+func (PendingRequest) message()          {}
+func (CapabilityDowngrade) message()     {}
+func (UnknownEvent) message()            {}
 
 type Event interface {
 	Message
@@ -104,6 +111,9 @@ const (
 	EventTypeSubagentEvent           EventType = "SubagentEvent"
 	EventTypeApprovalRequestResolved EventType = "ApprovalRequestResolved"
 	EventTypeApprovalResponse        EventType = "ApprovalResponse"
+	EventTypeToolCacheHit            EventType = "ToolCacheHit"
+	EventTypePendingRequest          EventType = "PendingRequest"
+	EventTypeCapabilityDowngrade     EventType = "CapabilityDowngrade"
 )
 
 func (TurnBegin) EventType() EventType               { return EventTypeTurnBegin }
@@ -120,6 +130,10 @@ func (ToolResult) EventType() EventType              { return EventTypeToolResul
 func (SubagentEvent) EventType() EventType           { return EventTypeSubagentEvent }
 func (ApprovalRequestResolved) EventType() EventType { return EventTypeApprovalRequestResolved }
 func (ApprovalResponse) EventType() EventType        { return EventTypeApprovalResponse }
+func (ToolCacheHit) EventType() EventType            { return EventTypeToolCacheHit }
+func (PendingRequest) EventType() EventType          { return EventTypePendingRequest }
+func (CapabilityDowngrade) EventType() EventType     { return EventTypeCapabilityDowngrade }
+func (e UnknownEvent) EventType() EventType          { return EventType(e.Type) }
 
 func unmarshalEvent[E Event](data []byte) (Event, error) {
 	var event E
@@ -154,9 +168,16 @@ func (params *EventParams) UnmarshalJSON(data []byte) (err error) {
 	if err := json.Unmarshal(data, &discriminator); err != nil {
 		return err
 	}
+	if discriminator.Type == "" {
+		return errors.New("missing event type")
+	}
 	unmarshaler, ok := eventUnmarshaler[discriminator.Type]
 	if !ok {
-		return fmt.Errorf("unknown event type: %q", discriminator.Type)
+		// Newer CLIs may send events this package predates; pass them on
+		// rather than failing the stream.
+		params.Type = discriminator.Type
+		params.Payload = UnknownEvent{Type: string(discriminator.Type), Raw: slices.Clone(discriminator.Payload)}
+		return nil
 	}
 	if params.Payload, err = unmarshaler(discriminator.Payload); err != nil {
 		return err
@@ -257,6 +278,19 @@ func NewImageContentPart(url string) ContentPart {
 	}
 }
 
+// NewImageDataContentPart returns an image part carrying data inline as a
+// base64 data URL, the form the CLI accepts for local images. mediaType is
+// the image's MIME type, e.g. "image/png". The encoded image is a third larger
+// than data and travels with the prompt.
+func NewImageDataContentPart(mediaType string, data []byte) ContentPart {
+	return NewImageContentPart(DataURL(mediaType, data))
+}
+
+// DataURL returns a base64 data URL for data of the given MIME type.
+func DataURL(mediaType string, data []byte) string {
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
 func NewAudioContentPart(url string) ContentPart {
 	return ContentPart{
 		Type:     ContentPartTypeAudioURL,
@@ -337,10 +371,32 @@ type (
 	CompactionEnd   struct{}
 )
 
+// StatusUpdate reports the progress of a step. Fields the CLI leaves out, or
+// sends as null to mean unchanged, are not Valid.
 type StatusUpdate struct {
-	ContextUsage Optional[float64]    `json:"context_usage,omitzero"`
-	TokenUsage   Optional[TokenUsage] `json:"token_usage,omitzero"`
-	MessageID    Optional[string]     `json:"message_id,omitzero"`
+	// ContextUsage is the fraction of the model's context window in use,
+	// between 0 and 1.
+	ContextUsage Optional[float64] `json:"context_usage,omitzero"`
+	// TokenUsage counts the tokens of the current step.
+	TokenUsage Optional[TokenUsage] `json:"token_usage,omitzero"`
+	MessageID  Optional[string]     `json:"message_id,omitzero"`
+	// PlanMode reports whether plan mode is active.
+	PlanMode Optional[bool] `json:"plan_mode,omitzero"`
+
+	// Raw is the payload as the CLI sent it, including fields this version
+	// of the SDK does not decode. It is not marshaled.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (u *StatusUpdate) UnmarshalJSON(data []byte) error {
+	type fields StatusUpdate
+	var decoded fields
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*u = StatusUpdate(decoded)
+	u.Raw = slices.Clone(data)
+	return nil
 }
 
 type TokenUsage struct {
@@ -350,6 +406,17 @@ type TokenUsage struct {
 	InputCacheCreation int `json:"input_cache_creation"`
 }
 
+// Input returns the number of input tokens, including those read from or
+// written to the provider's prompt cache.
+func (u TokenUsage) Input() int {
+	return u.InputOther + u.InputCacheRead + u.InputCacheCreation
+}
+
+// Total returns the number of input and output tokens.
+func (u TokenUsage) Total() int {
+	return u.Input() + u.Output
+}
+
 type ContentPartType string
 
 const (
@@ -453,6 +520,44 @@ type ToolCallRequest struct {
 	Arguments Optional[string] `json:"arguments,omitzero"`
 }
 
+// ToolCacheHit is emitted by the SDK (not the CLI) when an external tool call
+// is answered from the session's tool cache instead of invoking the tool.
+type ToolCacheHit struct {
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+}
+
+// PendingRequest is emitted by the SDK (not the CLI) on Turn.Events for a
+// request that awaits a response, such as an ApprovalRequest, since requests
+// are not events themselves. Respond to it through Request.Respond.
+type PendingRequest struct {
+	Request Request `json:"-"`
+}
+
+// CapabilityDowngrade is emitted by the SDK (not the CLI) at the start of the
+// first step of a session's first turn when a requested capability was turned
+// off because the model does not support it, e.g. with
+// kimi.WithThinkingBestEffort.
+type CapabilityDowngrade struct {
+	Capability string `json:"capability"`
+	Model      string `json:"model"`
+}
+
+// UnknownEvent is an event of a type this package does not know, e.g. one
+// added by a CLI newer than the SDK. Type is the event type the CLI sent, and
+// Raw its payload as received. It marshals back to Raw.
+type UnknownEvent struct {
+	Type string
+	Raw  json.RawMessage
+}
+
+func (e UnknownEvent) MarshalJSON() ([]byte, error) {
+	if len(e.Raw) == 0 {
+		return []byte("null"), nil
+	}
+	return e.Raw, nil
+}
+
 type DisplayBlockType string
 
 const (
@@ -3,6 +3,7 @@ package wire
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +64,9 @@ func TestEvent_EventTypeConstants(t *testing.T) {
 		{"SubagentEvent", SubagentEvent{}, EventTypeSubagentEvent},
 		{"ApprovalRequestResolved", ApprovalRequestResolved{}, EventTypeApprovalRequestResolved},
 		{"ApprovalResponse", ApprovalResponse{}, EventTypeApprovalResponse},
+		{"ToolCacheHit", ToolCacheHit{}, EventTypeToolCacheHit},
+		{"PendingRequest", PendingRequest{}, EventTypePendingRequest},
+		{"CapabilityDowngrade", CapabilityDowngrade{}, EventTypeCapabilityDowngrade},
 	}
 
 	for _, tc := range cases {
@@ -166,6 +170,16 @@ func (f badResponderFunc) Respond(r RequestResponse) error {
 	return f(r)
 }
 
+func TestTokenUsage_Totals(t *testing.T) {
+	usage := TokenUsage{InputOther: 100, Output: 50, InputCacheRead: 20, InputCacheCreation: 5}
+	if got := usage.Input(); got != 125 {
+		t.Errorf("expected Input()=125, got %d", got)
+	}
+	if got := usage.Total(); got != 175 {
+		t.Errorf("expected Total()=175, got %d", got)
+	}
+}
+
 func TestPromptResult_UnmarshalJSON_WithSteps(t *testing.T) {
 	var pr PromptResult
 	if err := json.Unmarshal([]byte(`{"status":"finished","steps":3}`), &pr); err != nil {
@@ -241,7 +255,7 @@ func TestEventParams_UnmarshalJSON_AllEventTypes(t *testing.T) {
 		{"StepInterrupted", EventTypeStepInterrupted, StepInterrupted{}},
 		{"CompactionBegin", EventTypeCompactionBegin, CompactionBegin{}},
 		{"CompactionEnd", EventTypeCompactionEnd, CompactionEnd{}},
-		{"StatusUpdate", EventTypeStatusUpdate, StatusUpdate{ContextUsage: Optional[float64]{Value: 0.5, Valid: true}}},
+		{"StatusUpdate", EventTypeStatusUpdate, StatusUpdate{ContextUsage: Optional[float64]{Value: 0.5, Valid: true}, Raw: json.RawMessage(`{"context_usage":0.5}`)}},
 		{"ContentPart", EventTypeContentPart, NewTextContentPart("hello")},
 		{"ToolCall", EventTypeToolCall, ToolCall{Type: "function", ID: "1", Function: ToolCallFunction{Name: "f"}}},
 		{"ToolCallPart", EventTypeToolCallPart, ToolCallPart{ArgumentsPart: Optional[string]{Value: "x", Valid: true}}},
@@ -281,11 +295,32 @@ func TestEventParams_UnmarshalJSON_AllEventTypes(t *testing.T) {
 	}
 }
 
-func TestEventParams_UnmarshalJSON_UnknownTypeReturnsError(t *testing.T) {
+func TestEventParams_UnmarshalJSON_UnknownType(t *testing.T) {
+	data := []byte(`{"type":"DoesNotExist","payload":{"n":1}}`)
 	var p EventParams
-	err := json.Unmarshal([]byte(`{"type":"DoesNotExist","payload":{}}`), &p)
-	if err == nil {
-		t.Fatalf("expected error for unknown event type")
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	unknown, ok := p.Payload.(UnknownEvent)
+	if !ok {
+		t.Fatalf("expected UnknownEvent, got %T", p.Payload)
+	}
+	if p.Type != "DoesNotExist" || unknown.EventType() != "DoesNotExist" || string(unknown.Raw) != `{"n":1}` {
+		t.Errorf("unexpected event %+v", unknown)
+	}
+	out, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(out) != string(data) {
+		t.Errorf("expected %s to round-trip, got %s", data, out)
+	}
+}
+
+func TestEventParams_UnmarshalJSON_MissingTypeReturnsError(t *testing.T) {
+	var p EventParams
+	if err := json.Unmarshal([]byte(`{"payload":{}}`), &p); err == nil {
+		t.Fatal("expected error for a missing event type")
 	}
 }
 
@@ -331,3 +366,86 @@ func TestRequestParams_UnmarshalJSON_UnknownTypeReturnsError(t *testing.T) {
 		t.Fatalf("expected error for unknown request type")
 	}
 }
+
+func TestEventParams_UnmarshalJSON_InvalidUTF8(t *testing.T) {
+	data := []byte("{\"type\":\"ContentPart\",\"payload\":{\"type\":\"text\",\"text\":\"ok \xff\xfe done\"}}")
+
+	var params EventParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		t.Fatalf("expected invalid UTF-8 to be tolerated, got %v", err)
+	}
+	cp, ok := params.Payload.(ContentPart)
+	if !ok {
+		t.Fatalf("expected ContentPart, got %T", params.Payload)
+	}
+	if want := "ok �� done"; cp.Text.Value != want {
+		t.Fatalf("expected invalid bytes to be replaced: want %q, got %q", want, cp.Text.Value)
+	}
+	if _, err := json.Marshal(cp); err != nil {
+		t.Fatalf("expected replaced text to re-encode, got %v", err)
+	}
+}
+
+func TestStatusUpdate_UnmarshalJSON(t *testing.T) {
+	data := `{"type":"StatusUpdate","payload":{"context_usage":0.25,"token_usage":{"input_other":1,"output":2,"input_cache_read":3,"input_cache_creation":4},"message_id":null,"plan_mode":true,"step_label":"searching"}}`
+	var params EventParams
+	if err := json.Unmarshal([]byte(data), &params); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	update, ok := params.Payload.(StatusUpdate)
+	if !ok {
+		t.Fatalf("expected StatusUpdate, got %T", params.Payload)
+	}
+	if !update.ContextUsage.Valid || update.ContextUsage.Value != 0.25 {
+		t.Errorf("expected context usage 0.25, got %+v", update.ContextUsage)
+	}
+	if want := (TokenUsage{InputOther: 1, Output: 2, InputCacheRead: 3, InputCacheCreation: 4}); update.TokenUsage.Value != want {
+		t.Errorf("expected token usage %+v, got %+v", want, update.TokenUsage.Value)
+	}
+	if update.MessageID.Valid {
+		t.Errorf("expected a null message ID to be invalid, got %+v", update.MessageID)
+	}
+	if !update.PlanMode.Valid || !update.PlanMode.Value {
+		t.Errorf("expected plan mode on, got %+v", update.PlanMode)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(update.Raw, &raw); err != nil {
+		t.Fatalf("Unmarshal Raw: %v", err)
+	}
+	if raw["step_label"] != "searching" {
+		t.Errorf("expected Raw to keep the unknown field, got %s", update.Raw)
+	}
+
+	out, err := json.Marshal(update)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Contains(string(out), "step_label") || strings.Contains(string(out), "Raw") {
+		t.Errorf("expected Raw not to be marshaled, got %s", out)
+	}
+}
+
+func TestContent_Multimodal_RoundTrip(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\n")
+	content := NewContent(
+		NewTextContentPart("What is in this picture?"),
+		NewImageDataContentPart("image/png", image),
+	)
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `[{"type":"text","text":"What is in this picture?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}}]`
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	var decoded Content
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, content) {
+		t.Errorf("round trip mismatch:\n got: %+v\nwant: %+v", decoded, content)
+	}
+}